@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"keyviewer/internal/api"
+)
+
+// runRender handles "keyviewer render [flags] KEYMAP", which prints keymap
+// layers as text. KEYMAP is a stored keymap's name or a keymap file.
+func runRender(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: keyviewer render [flags] KEYMAP")
+		fmt.Fprintln(stderr, "Prints keymap layers as text. KEYMAP is a stored keymap or a .keymap or .json file.")
+		flags.PrintDefaults()
+	}
+	layers := flags.String("layer", "", "layers to print by index or name, comma-separated (default all)")
+	layout := flags.String("layout", "", "stored layout to draw on (default the keymap's own)")
+	variant := flags.String("variant", "", "layout options as group:choice,...")
+	style := flags.String("style", "box", "borders: ascii or box")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := api.TextOptions{
+		Keymap:  flags.Arg(0),
+		Layout:  *layout,
+		Variant: *variant,
+		Style:   *style,
+	}
+	if *layers != "" {
+		for _, name := range strings.Split(*layers, ",") {
+			opts.Layers = append(opts.Layers, strings.TrimSpace(name))
+		}
+	}
+	text, err := api.RenderText(opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "keyviewer render:", err)
+		return 1
+	}
+	fmt.Fprint(stdout, text)
+	return 0
+}
@@ -1,3 +1,10 @@
 module keyviewer
 
 go 1.25.6
+
+require (
+	golang.org/x/image v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/text v0.23.0 // indirect
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"keyviewer/internal/parser"
+)
+
+// layoutRef returns a reference to a stored layout
+func layoutRef(name string, layout *parser.Layout) *parser.LayoutRef {
+	return &parser.LayoutRef{Name: name, Hash: parser.LayoutHash(layout)}
+}
+
+// keymapLayout returns the layout a keymap is drawn on: the stored layout it
+// references, or else the layout embedded in it. name is the name position
+// maps and other per-layout files go by: the referenced name when there is a
+// reference. changed is set when the stored layout was edited since the
+// keymap was associated with it.
+func keymapLayout(keymap *parser.Keymap) (layout *parser.Layout, name string, changed bool) {
+	if keymap.LayoutRef != nil {
+		name = keymap.LayoutRef.Name
+		if stored, err := loadLayout(name); err == nil {
+			changed = parser.LayoutHash(stored) != keymap.LayoutRef.Hash
+			stored.Name = name
+			return stored, name, changed
+		}
+	}
+	if keymap.Layout == nil {
+		return nil, "", false
+	}
+	if name == "" {
+		name = keymap.Layout.Name
+	}
+	return keymap.Layout, name, false
+}
+
+// registerLayout stores a layout that came embedded in a keymap and returns a
+// reference to it. A stored layout with the same name and content is reused;
+// one with the same name but other content is left alone and the embedded
+// layout is stored under its name plus hash instead.
+func registerLayout(layout *parser.Layout, fallbackName string) (*parser.LayoutRef, error) {
+	name := layout.Name
+	if name == "" {
+		name = fallbackName
+	}
+	hash := parser.LayoutHash(layout)
+
+	if stored, err := loadLayout(name); err == nil {
+		if parser.LayoutHash(stored) == hash {
+			return &parser.LayoutRef{Name: name, Hash: hash}, nil
+		}
+		name += "-" + hash
+	}
+
+	named := *layout
+	named.Name = name
+	if err := saveLayout(&named, name); err != nil {
+		return nil, err
+	}
+	return &parser.LayoutRef{Name: name, Hash: hash}, nil
+}
+
+// handleKeymapLayout handles /api/keymap/{name}/layout, the stored layout a
+// keymap is associated with. GET returns the reference, PUT {"name": ...}
+// points the keymap at a stored layout and DELETE drops the association.
+func handleKeymapLayout(w http.ResponseWriter, r *http.Request, keymapName string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keymap, err := loadKeymap(keymapName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Keymap not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read keymap", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var update struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil || update.Name == "" {
+			http.Error(w, "Layout name required", http.StatusBadRequest)
+			return
+		}
+		layout, err := loadLayout(update.Name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Layout not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+			}
+			return
+		}
+		keymap.LayoutRef = layoutRef(update.Name, layout)
+		keymap.Layout = nil
+		if _, err := saveKeymap(keymap); err != nil {
+			http.Error(w, "Failed to save keymap", http.StatusInternalServerError)
+			return
+		}
+
+	case http.MethodDelete:
+		keymap.LayoutRef = nil
+		if _, err := saveKeymap(keymap); err != nil {
+			http.Error(w, "Failed to save keymap", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keymap.LayoutRef)
+}
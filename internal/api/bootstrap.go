@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"keyviewer/internal/parser"
+)
+
+// handleLayoutKeymap handles POST /api/layout/{name}/keymap, which creates a
+// starter keymap from the legends of a layout. The keymap is named after the
+// layout unless a "name" query parameter is given. An existing keymap of that
+// name is only replaced with ?overwrite=1.
+func handleLayoutKeymap(w http.ResponseWriter, r *http.Request, layoutName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	layout, err := loadLayout(layoutName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Layout not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = strings.TrimSuffix(layoutName, ".kle")
+	}
+
+	if r.URL.Query().Get("overwrite") != "1" {
+		if _, err := os.Stat(filepath.Join(keymapsDir, name+".json")); err == nil {
+			http.Error(w, "Keymap already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	keymap, unmapped := parser.KeymapFromLayout(layout, name)
+	keymap.LayoutRef = layoutRef(layoutName, layout)
+	if _, err := saveKeymap(keymap); err != nil {
+		http.Error(w, "Failed to save keymap", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Keymap   *parser.Keymap          `json:"keymap"`
+		Unmapped []parser.UnmappedLegend `json:"unmapped"`
+	}{keymap, unmapped})
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"keyviewer/internal/parser"
+	"keyviewer/internal/render"
+)
+
+// TextOptions are the settings of RenderText
+type TextOptions struct {
+	Keymap  string   // Stored keymap name, or the path of a .keymap or keymap JSON file
+	Layout  string   // Stored layout to draw on instead of the keymap's own
+	Variant string   // Layout options, group:choice,...
+	Layers  []string // Layers by index or name, all when empty
+	Style   string   // render.TextASCII or render.TextBox
+}
+
+// RenderText draws layers of a keymap as text for the command line, reading
+// the same keymaps and layouts directories as the server. A keymap file
+// without a layout is drawn on the stored layout that clearly fits it best,
+// as on upload. A single layer is drawn without its title.
+func RenderText(opts TextOptions) (string, error) {
+	keymap, source, err := openKeymap(opts.Keymap)
+	if err != nil {
+		return "", err
+	}
+
+	var layout *parser.Layout
+	var layoutName string
+	switch {
+	case opts.Layout != "":
+		if layout, err = loadLayout(opts.Layout); err != nil {
+			return "", fmt.Errorf("layout %s: %w", opts.Layout, err)
+		}
+		layoutName = opts.Layout
+	default:
+		layout, layoutName, _ = keymapLayout(keymap)
+		if layout == nil {
+			best, ok := parser.UnambiguousMatch(suggestLayouts(keymap, source))
+			if !ok {
+				return "", fmt.Errorf("keymap %s has no layout and none fits it clearly", keymap.Name)
+			}
+			if layout, err = loadLayout(best.Layout); err != nil {
+				return "", fmt.Errorf("layout %s: %w", best.Layout, err)
+			}
+			layoutName = best.Layout
+		}
+	}
+	if layout, err = selectVariant(layout, opts.Variant); err != nil {
+		return "", fmt.Errorf("invalid variant: %w", err)
+	}
+	bound := parser.BindLayout(layout, joinKeymap(layoutName, layout, keymap))
+
+	var layers []int
+	for _, name := range opts.Layers {
+		i := findLayer(keymap, name)
+		if i < 0 {
+			return "", fmt.Errorf("layer not found: %s", name)
+		}
+		layers = append(layers, i)
+	}
+	scenes, err := render.LayerScenes(keymap, bound, layers)
+	if err != nil {
+		return "", err
+	}
+	if len(scenes) == 1 {
+		return render.Text(scenes[0], opts.Style)
+	}
+	return render.StackedText(scenes, opts.Style)
+}
+
+// openKeymap reads a keymap file, JSON or ZMK source, or else a stored keymap
+// by name. It also returns the keymap's source, when there is one.
+func openKeymap(path string) (*parser.Keymap, string, error) {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		keymap, err := loadKeymap(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("keymap %s: %w", path, err)
+		}
+		source, _ := os.ReadFile(filepath.Join(keymapsDir, path+".keymap"))
+		return keymap, string(source), nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if filepath.Ext(path) == ".json" {
+		var keymap parser.Keymap
+		if err := json.Unmarshal(content, &keymap); err != nil {
+			return nil, "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		return &keymap, "", nil
+	}
+	keymap, err := parser.ParseKeymap(string(content), name)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse keymap: %w", err)
+	}
+	return keymap, string(content), nil
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+
+	"keyviewer/internal/parser"
+)
+
+// handleLayoutExport handles GET /api/layout/{name}/export?format=kle, which
+// serializes a layout back to KLE raw data. With keymap=X the legends come
+// from layer N (layer=N, default 0) of that keymap instead of the layout, and
+// variant=group:choice,... picks the layout options to export.
+func handleLayoutExport(w http.ResponseWriter, r *http.Request, layoutName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "kle" {
+		http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		return
+	}
+
+	layout, err := loadLayout(layoutName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Layout not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	layout, err = layoutVariant(layout, r)
+	if err != nil {
+		http.Error(w, "Invalid variant: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var layer *parser.Layer
+	filename := layoutName
+	if keymapName := query.Get("keymap"); keymapName != "" {
+		keymap, err := loadKeymap(keymapName)
+		if err != nil {
+			if os.IsNotExist(err) {
+				http.Error(w, "Keymap not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to read keymap", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		layerIndex := 0
+		if s := query.Get("layer"); s != "" {
+			layerIndex, err = strconv.Atoi(s)
+			if err != nil {
+				http.Error(w, "Invalid layer index", http.StatusBadRequest)
+				return
+			}
+		}
+		if layerIndex < 0 || layerIndex >= len(keymap.Layers) {
+			http.Error(w, "Invalid layer index", http.StatusBadRequest)
+			return
+		}
+
+		layout = parser.BindLayout(layout, joinKeymap(layoutName, layout, keymap))
+		layer = &keymap.Layers[layerIndex]
+		filename = keymapName + "-" + strconv.Itoa(layerIndex)
+	}
+
+	data, err := parser.ExportKLE(layout, layer)
+	if err != nil {
+		http.Error(w, "Failed to export layout: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.kle.json"`)
+	w.Write(data)
+}
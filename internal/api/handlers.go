@@ -14,10 +14,18 @@ import (
 
 const keymapsDir = "keymaps"
 const layoutsDir = "layouts"
+const positionsDir = "positions"
+
+// glove80Layout is the bundled layout used for Glove80 Layout Editor imports
+const glove80Layout = "glove80"
+
+// oryxLayouts are the bundled layouts named after an Oryx geometry
+var oryxLayouts = map[string]bool{"moonlander": true, "voyager": true, "ergodox-ez": true}
 
 func init() {
 	os.MkdirAll(keymapsDir, 0755)
 	os.MkdirAll(layoutsDir, 0755)
+	os.MkdirAll(positionsDir, 0755)
 }
 
 // HandleKeymap handles POST requests to upload and parse a keymap
@@ -54,6 +62,18 @@ func HandleKeymap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Keep the layout a previous upload of this keymap was associated with, so
+	// a manual choice survives re-uploads; otherwise associate the stored layout
+	// that clearly fits best. The ranking is returned either way.
+	suggestions := suggestLayouts(keymap, string(content))
+	if previous, err := loadKeymap(name); err == nil && previous.LayoutRef != nil {
+		keymap.LayoutRef = previous.LayoutRef
+	} else if best, ok := parser.UnambiguousMatch(suggestions); ok {
+		if layout, err := loadLayout(best.Layout); err == nil {
+			keymap.LayoutRef = layoutRef(best.Layout, layout)
+		}
+	}
+
 	jsonData, err := json.MarshalIndent(keymap, "", "  ")
 	if err != nil {
 		http.Error(w, "Failed to serialize keymap", http.StatusInternalServerError)
@@ -67,8 +87,18 @@ func HandleKeymap(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Keep the source for features that read its comments, like position auto-matching
+	sourcePath := filepath.Join(keymapsDir, name+".keymap")
+	if err := os.WriteFile(sourcePath, content, 0644); err != nil {
+		http.Error(w, "Failed to save keymap source", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	json.NewEncoder(w).Encode(struct {
+		*parser.Keymap
+		LayoutSuggestions []parser.LayoutMatch `json:"layoutSuggestions"`
+	}{keymap, suggestions})
 }
 
 // HandleKeymaps handles GET requests to list available keymaps
@@ -110,10 +140,53 @@ func HandleKeymapImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate JSON structure
 	var keymap parser.Keymap
-	if err := json.Unmarshal(body, &keymap); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		// Validate JSON structure
+		if err := json.Unmarshal(body, &keymap); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	case "keymap-drawer":
+		// keymap-drawer files carry no name, so it comes from the query
+		parsed, layout, err := parser.ParseKeymapDrawer(body, r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "Invalid keymap-drawer YAML: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		parsed.Layout = layout
+		keymap = *parsed
+
+	case "glove80":
+		parsed, err := parser.ParseGlove80(body, r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "Invalid Glove80 export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Glove80 exports carry no geometry, use the bundled layout
+		if layout, err := loadLayout(glove80Layout); err == nil {
+			parsed.LayoutRef = layoutRef(glove80Layout, layout)
+		}
+		keymap = *parsed
+
+	case "oryx":
+		parsed, geometry, err := parser.ParseOryx(body, r.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, "Invalid Oryx export: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The geometry comes from the upload, so only bundled layout names are looked up
+		if oryxLayouts[geometry] {
+			if layout, err := loadLayout(geometry); err == nil {
+				parsed.LayoutRef = layoutRef(geometry, layout)
+			}
+		}
+		keymap = *parsed
+
+	default:
+		http.Error(w, "Unsupported import format", http.StatusBadRequest)
 		return
 	}
 
@@ -127,6 +200,21 @@ func HandleKeymapImport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Embedded layouts are stored as layouts of their own and referenced
+	if keymap.Layout != nil {
+		ref, err := registerLayout(keymap.Layout, keymap.Name)
+		if err != nil {
+			http.Error(w, "Failed to save layout", http.StatusInternalServerError)
+			return
+		}
+		keymap.LayoutRef = ref
+		keymap.Layout = nil
+	} else if keymap.LayoutRef == nil {
+		if previous, err := loadKeymap(keymap.Name); err == nil {
+			keymap.LayoutRef = previous.LayoutRef
+		}
+	}
+
 	// Re-marshal with proper formatting
 	jsonData, err := json.MarshalIndent(keymap, "", "  ")
 	if err != nil {
@@ -146,14 +234,37 @@ func HandleKeymapImport(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonData)
 }
 
-// HandleKeymapByName handles GET and PATCH requests for a specific keymap
+// HandleKeymapByName handles requests for a specific keymap and its sub-resources
 func HandleKeymapByName(w http.ResponseWriter, r *http.Request) {
-	name := strings.TrimPrefix(r.URL.Path, "/api/keymap/")
+	name, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/keymap/"), "/")
 	if name == "" {
 		http.Error(w, "Keymap name required", http.StatusBadRequest)
 		return
 	}
 
+	switch {
+	case resource == "":
+		handleKeymapGetPatch(w, r, name)
+	case resource == "layout":
+		handleKeymapLayout(w, r, name)
+	case strings.HasPrefix(resource, "layer/"):
+		handleKeymapLayerImage(w, r, name, strings.TrimPrefix(resource, "layer/"))
+	case strings.HasPrefix(resource, "overview."):
+		handleKeymapOverview(w, r, name, resource)
+	case resource == "export.pdf":
+		handleKeymapPDF(w, r, name)
+	case resource == "art":
+		handleKeymapArt(w, r, name)
+	case resource == "format":
+		handleKeymapFormat(w, r, name)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleKeymapGetPatch handles GET and PATCH requests for a stored keymap.
+// GET ?withLayout=1 returns the keymap together with its layout.
+func handleKeymapGetPatch(w http.ResponseWriter, r *http.Request, name string) {
 	jsonPath := filepath.Join(keymapsDir, name+".json")
 
 	switch r.Method {
@@ -167,8 +278,48 @@ func HandleKeymapByName(w http.ResponseWriter, r *http.Request) {
 			}
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(data)
+
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			if r.URL.Query().Get("withLayout") == "1" {
+				var keymap parser.Keymap
+				if err := json.Unmarshal(data, &keymap); err != nil {
+					http.Error(w, "Failed to parse keymap", http.StatusInternalServerError)
+					return
+				}
+				layout, _, changed := keymapLayout(&keymap)
+				keymap.Layout = nil
+				data, err = json.Marshal(parser.KeymapWithLayout{Keymap: &keymap, Layout: layout, LayoutChanged: changed})
+				if err != nil {
+					http.Error(w, "Failed to serialize keymap", http.StatusInternalServerError)
+					return
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(data)
+
+		case "keymap-drawer":
+			var keymap parser.Keymap
+			if err := json.Unmarshal(data, &keymap); err != nil {
+				http.Error(w, "Failed to parse keymap", http.StatusInternalServerError)
+				return
+			}
+			layout, layoutName, _ := keymapLayout(&keymap)
+			if layout != nil {
+				layout = parser.BindLayout(layout, joinKeymap(layoutName, layout, &keymap))
+			}
+			yamlData, err := parser.ExportKeymapDrawer(&keymap, layout)
+			if err != nil {
+				http.Error(w, "Failed to export keymap: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.yaml"`)
+			w.Write(yamlData)
+
+		default:
+			http.Error(w, "Unsupported export format", http.StatusBadRequest)
+		}
 
 	case http.MethodPatch:
 		// Update custom key name
@@ -235,7 +386,8 @@ func HandleKeymapByName(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// HandleLayout handles POST requests to upload a KLE layout
+// HandleLayout handles POST requests to upload a KLE, QMK, ZMK or Ergogen layout.
+// The response carries the parsed layout and the warnings found by ValidateLayout.
 func HandleLayout(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -263,27 +415,75 @@ func HandleLayout(w http.ResponseWriter, r *http.Request) {
 
 	name := strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
 
-	layout, err := parser.ParseKLELayout(content, name)
+	var layout *parser.Layout
+	if parser.IsVIADefinition(content) {
+		// VIA and Vial definitions are usually named via.json or vial.json, use the keyboard name instead
+		if name == "via" || name == "vial" {
+			name = ""
+		}
+		layout, err = parser.ParseVIADefinition(content, name)
+		if err == nil && name == "" {
+			layout.Name = slugName(layout.Name)
+			name = layout.Name
+		}
+	} else if parser.IsQMKInfoLayout(content) {
+		// QMK files are always named info.json or keyboard.json, use the keyboard name instead
+		if name == "info" || name == "keyboard" {
+			name = ""
+		}
+		layout, err = parser.ParseQMKInfoLayout(content, name, r.FormValue("variant"))
+		if err == nil && name == "" {
+			layout.Name = slugName(layout.Name)
+			name = layout.Name
+		}
+	} else if parser.IsZMKPhysicalLayout(content) {
+		layout, err = parser.ParseZMKPhysicalLayout(content, name, r.FormValue("variant"))
+	} else if parser.IsErgogenConfig(content) {
+		layout, err = parser.ParseErgogenPoints(content, name)
+	} else {
+		layout, err = parser.ParseKLELayout(content, name)
+	}
 	if err != nil {
 		http.Error(w, "Failed to parse layout: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	jsonData, err := json.MarshalIndent(layout, "", "  ")
-	if err != nil {
-		http.Error(w, "Failed to serialize layout", http.StatusInternalServerError)
+	if name == "" {
+		http.Error(w, "Layout name required", http.StatusBadRequest)
 		return
 	}
 
-	jsonPath := filepath.Join(layoutsDir, name+".json")
-	err = os.WriteFile(jsonPath, jsonData, 0644)
-	if err != nil {
+	if err := saveLayout(layout, name); err != nil {
 		http.Error(w, "Failed to save layout", http.StatusInternalServerError)
 		return
 	}
 
+	// Problems in the layout are reported, not rejected
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	json.NewEncoder(w).Encode(struct {
+		Layout   *parser.Layout         `json:"layout"`
+		Warnings []parser.LayoutWarning `json:"warnings"`
+	}{layout, parser.ValidateLayout(layout)})
+}
+
+// slugName turns a keyboard name from an uploaded file into a file name:
+// lower case letters, digits, dots and underscores, with dashes between words.
+// Leading dots are dropped, so the name stays a plain file in its directory.
+func slugName(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+	return strings.TrimLeft(b.String(), ".-")
 }
 
 // HandleLayouts handles GET requests to list available layouts
@@ -312,21 +512,40 @@ func HandleLayouts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(names)
 }
 
-// HandleLayoutByName handles GET requests for a specific layout
+// HandleLayoutByName handles requests for a specific layout and its sub-resources
 func HandleLayoutByName(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	name, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/layout/"), "/")
+	if name == "" {
+		http.Error(w, "Layout name required", http.StatusBadRequest)
 		return
 	}
 
-	name := strings.TrimPrefix(r.URL.Path, "/api/layout/")
-	if name == "" {
-		http.Error(w, "Layout name required", http.StatusBadRequest)
+	switch resource {
+	case "":
+		handleLayoutGet(w, r, name)
+	case "keymap":
+		handleLayoutKeymap(w, r, name)
+	case "positions":
+		handleLayoutPositions(w, r, name)
+	case "export":
+		handleLayoutExport(w, r, name)
+	case "transform":
+		handleLayoutTransform(w, r, name)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleLayoutGet handles GET requests for a stored layout. Layouts with
+// option groups are returned as built with ?variant=group:choice,... (choice 0
+// of every group by default); ?variant=all returns every key.
+func handleLayoutGet(w http.ResponseWriter, r *http.Request, name string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	jsonPath := filepath.Join(layoutsDir, name+".json")
-	data, err := os.ReadFile(jsonPath)
+	layout, err := loadLayout(name)
 	if err != nil {
 		if os.IsNotExist(err) {
 			http.Error(w, "Layout not found", http.StatusNotFound)
@@ -336,6 +555,94 @@ func HandleLayoutByName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	layout, err = layoutVariant(layout, r)
+	if err != nil {
+		http.Error(w, "Invalid variant: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// ?geometry=1 adds rotated key outlines, centers and bounds
+	var data []byte
+	if r.URL.Query().Get("geometry") == "1" {
+		data, err = json.Marshal(struct {
+			*parser.Layout
+			Geometry parser.LayoutGeometry `json:"geometry"`
+		}{layout, parser.ComputeGeometry(layout)})
+	} else {
+		data, err = json.Marshal(layout)
+	}
+	if err != nil {
+		http.Error(w, "Failed to serialize layout", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(data)
 }
+
+// layoutVariant applies the variant query parameter to a layout with option groups
+func layoutVariant(layout *parser.Layout, r *http.Request) (*parser.Layout, error) {
+	return selectVariant(layout, r.URL.Query().Get("variant"))
+}
+
+// selectVariant applies a group:choice,... selection to a layout with option
+// groups; "all" keeps every option
+func selectVariant(layout *parser.Layout, variant string) (*parser.Layout, error) {
+	if variant == "all" || (variant == "" && len(layout.Variants) == 0) {
+		return layout, nil
+	}
+	selection, err := parser.ParseVariantSelection(variant)
+	if err != nil {
+		return nil, err
+	}
+	return parser.SelectVariant(layout, selection)
+}
+
+// loadLayout reads a stored layout by name
+func loadLayout(name string) (*parser.Layout, error) {
+	data, err := os.ReadFile(filepath.Join(layoutsDir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var layout parser.Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return nil, err
+	}
+	return &layout, nil
+}
+
+// saveLayout writes a layout to the layouts directory under the given name
+func saveLayout(layout *parser.Layout, name string) error {
+	jsonData, err := json.MarshalIndent(layout, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(layoutsDir, name+".json"), jsonData, 0644)
+}
+
+// loadKeymap reads a stored keymap by name
+func loadKeymap(name string) (*parser.Keymap, error) {
+	data, err := os.ReadFile(filepath.Join(keymapsDir, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var keymap parser.Keymap
+	if err := json.Unmarshal(data, &keymap); err != nil {
+		return nil, err
+	}
+	return &keymap, nil
+}
+
+// saveKeymap writes a keymap to the keymaps directory and returns its JSON
+func saveKeymap(keymap *parser.Keymap) ([]byte, error) {
+	jsonData, err := json.MarshalIndent(keymap, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(keymapsDir, keymap.Name+".json"), jsonData, 0644); err != nil {
+		return nil, err
+	}
+	return jsonData, nil
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"os"
+	"strings"
+
+	"keyviewer/internal/parser"
+)
+
+// suggestLayouts ranks the stored layouts against a keymap, using the ASCII
+// art in the keymap's source when there is any. Layouts with option groups
+// are scored as built with their default choices.
+func suggestLayouts(keymap *parser.Keymap, source string) []parser.LayoutMatch {
+	entries, err := os.ReadDir(layoutsDir)
+	if err != nil {
+		return []parser.LayoutMatch{}
+	}
+
+	var layouts []*parser.Layout
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		layout, err := loadLayout(name)
+		if err != nil {
+			continue
+		}
+		if len(layout.Variants) > 0 {
+			if layout, err = parser.SelectVariant(layout, nil); err != nil {
+				continue
+			}
+		}
+		// Suggestions name the stored file, which the layout's own name may not match
+		layout.Name = name
+		layouts = append(layouts, layout)
+	}
+
+	var art []parser.ArtCell
+	if source != "" {
+		art = parser.KeymapArt(source, keymap.BindingCount())
+	}
+	return parser.RankLayouts(layouts, keymap, art)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"keyviewer/internal/parser"
+)
+
+// storedPositions is a position map edited for a layout and a keymap format.
+// Positions holds the key index each binding lands on, -1 for none.
+type storedPositions struct {
+	Layout    string `json:"layout"`
+	Format    string `json:"format"`
+	Positions []int  `json:"positions"`
+}
+
+// positionsUpdate is the body of a PUT to /api/layout/{name}/positions
+type positionsUpdate struct {
+	Op        string `json:"op"`        // "swap", "reorder", "auto" or "reset"
+	Keys      []int  `json:"keys"`      // swap: the two key indices whose bindings trade places
+	Positions []int  `json:"positions"` // reorder: the key index for each binding
+}
+
+// keymapFormat returns the format position maps are stored under for a keymap
+func keymapFormat(keymap *parser.Keymap) string {
+	if keymap.Format == "" {
+		return "json"
+	}
+	return keymap.Format
+}
+
+// positionsPath returns the file a (layout, keymap format) position map is stored in
+func positionsPath(layoutName, format string) string {
+	return filepath.Join(positionsDir, layoutName+"."+format+".json")
+}
+
+// joinKeymap joins a keymap to a layout, applying the stored position map for
+// the layout and the keymap's format when there is one
+func joinKeymap(layoutName string, layout *parser.Layout, keymap *parser.Keymap) parser.KeyPositions {
+	data, err := os.ReadFile(positionsPath(layoutName, keymapFormat(keymap)))
+	if err == nil {
+		var stored storedPositions
+		if json.Unmarshal(data, &stored) == nil && len(stored.Positions) > 0 {
+			withMap := *layout
+			withMap.PositionMap = stored.Positions
+			return parser.JoinPositions(&withMap, keymap)
+		}
+	}
+	return parser.JoinPositions(layout, keymap)
+}
+
+// handleLayoutPositions handles /api/layout/{name}/positions?keymap=X. GET
+// reports the binding of keymap X that lands on each key of the layout; PUT
+// edits the position map stored for the layout and the keymap's format.
+func handleLayoutPositions(w http.ResponseWriter, r *http.Request, layoutName string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keymapName := r.URL.Query().Get("keymap")
+	if keymapName == "" {
+		http.Error(w, "Keymap name required", http.StatusBadRequest)
+		return
+	}
+
+	layout, err := loadLayout(layoutName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Layout not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	keymap, err := loadKeymap(keymapName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Keymap not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read keymap", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var update positionsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if status, msg := updatePositions(layoutName, layout, keymap, update); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(joinKeymap(layoutName, layout, keymap))
+}
+
+// updatePositions applies an edit to the stored position map, returning an HTTP
+// status and error message when the edit is rejected
+func updatePositions(layoutName string, layout *parser.Layout, keymap *parser.Keymap, update positionsUpdate) (int, string) {
+	format := keymapFormat(keymap)
+	path := positionsPath(layoutName, format)
+
+	bindingCount := keymap.BindingCount()
+	current := joinKeymap(layoutName, layout, keymap)
+
+	var positions []int
+	switch update.Op {
+	case "swap":
+		if len(update.Keys) != 2 {
+			return http.StatusBadRequest, "Swap needs two key indices"
+		}
+		a, b := update.Keys[0], update.Keys[1]
+		if a < 0 || b < 0 || a >= len(current.Bindings) || b >= len(current.Bindings) {
+			return http.StatusBadRequest, "Key index out of range"
+		}
+		current.Bindings[a], current.Bindings[b] = current.Bindings[b], current.Bindings[a]
+		positions = parser.PositionsToMap(current, bindingCount)
+
+	case "reorder":
+		if len(update.Positions) != bindingCount {
+			return http.StatusBadRequest, "Positions must list a key index for every binding"
+		}
+		used := make(map[int]bool)
+		for _, k := range update.Positions {
+			if k < -1 || k >= len(current.Bindings) {
+				return http.StatusBadRequest, "Key index out of range"
+			}
+			if k >= 0 && used[k] {
+				return http.StatusBadRequest, "Key index used more than once"
+			}
+			used[k] = true
+		}
+		positions = update.Positions
+
+	case "auto":
+		source, err := os.ReadFile(filepath.Join(keymapsDir, keymap.Name+".keymap"))
+		if err != nil {
+			return http.StatusBadRequest, "Keymap has no stored source to read ASCII art from"
+		}
+		cells := parser.KeymapArt(string(source), bindingCount)
+		if len(cells) == 0 {
+			return http.StatusBadRequest, "No ASCII art found in keymap comments"
+		}
+		positions = parser.AutoMatchPositions(layout, cells)
+
+	case "reset":
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return http.StatusInternalServerError, "Failed to reset positions"
+		}
+		return http.StatusOK, ""
+
+	default:
+		return http.StatusBadRequest, "Unsupported positions operation"
+	}
+
+	jsonData, err := json.MarshalIndent(storedPositions{
+		Layout:    layoutName,
+		Format:    format,
+		Positions: positions,
+	}, "", "  ")
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to serialize positions"
+	}
+	if err := os.WriteFile(path, jsonData, 0644); err != nil {
+		return http.StatusInternalServerError, "Failed to save positions"
+	}
+	return http.StatusOK, ""
+}
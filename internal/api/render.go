@@ -0,0 +1,338 @@
+package api
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"keyviewer/internal/parser"
+	"keyviewer/internal/render"
+)
+
+// handleKeymapLayerImage handles GET /api/keymap/{name}/layer/{i}.svg, .png
+// and .txt, a picture of one layer drawn on the keymap's layout. The layer is
+// given by index or by name; variant=group:choice,... picks the layout options,
+// scale=N enlarges PNGs and style=ascii|box picks the borders of text.
+func handleKeymapLayerImage(w http.ResponseWriter, r *http.Request, keymapName, file string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	layerName, format, ok := imageFile(w, file)
+	if !ok {
+		return
+	}
+
+	keymap, layout, ok := loadBoundKeymap(w, r, keymapName)
+	if !ok {
+		return
+	}
+	layerIndex := findLayer(keymap, layerName)
+	if layerIndex < 0 {
+		http.Error(w, "Layer not found", http.StatusNotFound)
+		return
+	}
+
+	scene, err := render.LayerScene(keymap, layout, layerIndex)
+	if err != nil {
+		http.Error(w, "Failed to render layer: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeImage(w, r, format, []*render.Scene{scene})
+}
+
+// handleKeymapOverview handles GET /api/keymap/{name}/overview.svg, .png and
+// .txt, a picture of several layers at once. mode=stacked (the default) draws the layers one
+// below the other; mode=compact draws the first layer with the others'
+// legends in the key corners. layers=0,Nav,... picks the layers by index or
+// name and variant=group:choice,... the layout options.
+func handleKeymapOverview(w http.ResponseWriter, r *http.Request, keymapName, file string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	_, format, ok := imageFile(w, file)
+	if !ok {
+		return
+	}
+
+	keymap, layout, ok := loadBoundKeymap(w, r, keymapName)
+	if !ok {
+		return
+	}
+	layers, ok := layerList(w, r, keymap)
+	if !ok {
+		return
+	}
+
+	var scenes []*render.Scene
+	switch r.URL.Query().Get("mode") {
+	case "", "stacked":
+		var err error
+		scenes, err = render.LayerScenes(keymap, layout, layers)
+		if err != nil {
+			http.Error(w, "Failed to render overview: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "compact":
+		scene, err := render.CompactScene(keymap, layout, layers)
+		if err != nil {
+			http.Error(w, "Failed to render overview: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		scenes = []*render.Scene{scene}
+	default:
+		http.Error(w, "Unsupported overview mode", http.StatusBadRequest)
+		return
+	}
+	writeImage(w, r, format, scenes)
+}
+
+// handleKeymapPDF handles GET /api/keymap/{name}/export.pdf, a printable
+// cheat sheet of the keymap. paper=a4|letter picks the paper, perPage=N how
+// many layers go on a page, layers=0,Nav,... the layers to print and
+// variant=group:choice,... the layout options.
+func handleKeymapPDF(w http.ResponseWriter, r *http.Request, keymapName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keymap, layout, ok := loadBoundKeymap(w, r, keymapName)
+	if !ok {
+		return
+	}
+	layers, ok := layerList(w, r, keymap)
+	if !ok {
+		return
+	}
+	opts := render.PDFOptions{Paper: r.URL.Query().Get("paper"), Layers: layers}
+	if s := r.URL.Query().Get("perPage"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > render.MaxLayersPerPage {
+			http.Error(w, "Invalid layers per page", http.StatusBadRequest)
+			return
+		}
+		opts.PerPage = n
+	}
+
+	data, err := render.PDF(keymap, layout, opts)
+	if err != nil {
+		http.Error(w, "Failed to export keymap: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+keymapName+`.pdf"`)
+	w.Write(data)
+}
+
+// handleKeymapArt handles GET /api/keymap/{name}/art, the keymap's uploaded
+// .keymap source with the comment before each layer replaced by a text picture
+// of the layer; the rest of the file is unchanged. style=ascii|box picks the
+// borders and variant=group:choice,... the layout options. Unlike text images,
+// which default to box, the art defaults to ascii: it goes into firmware
+// sources, which are often kept to plain ASCII.
+func handleKeymapArt(w http.ResponseWriter, r *http.Request, keymapName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source, ok := loadKeymapSource(w, keymapName)
+	if !ok {
+		return
+	}
+	keymap, layout, ok := loadBoundKeymap(w, r, keymapName)
+	if !ok {
+		return
+	}
+	style := r.URL.Query().Get("style")
+	if style == "" {
+		style = render.TextASCII
+	}
+
+	art := make([][]string, len(keymap.Layers))
+	for i := range keymap.Layers {
+		scene, err := render.LayerScene(keymap, layout, i)
+		if err != nil {
+			http.Error(w, "Failed to render layer: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		text, err := render.Text(scene, style)
+		if err != nil {
+			http.Error(w, "Failed to render text: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		art[i] = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+
+	writeKeymapSource(w, keymapName, parser.RewriteLayerArt(source, art))
+}
+
+// handleKeymapFormat handles GET /api/keymap/{name}/format, the keymap's
+// uploaded .keymap source with the bindings of each layer lined up in the rows
+// and columns of its layout's keys; comments and the rest of the file are
+// unchanged. variant=group:choice,... picks the layout options.
+func handleKeymapFormat(w http.ResponseWriter, r *http.Request, keymapName string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source, ok := loadKeymapSource(w, keymapName)
+	if !ok {
+		return
+	}
+	_, layout, ok := loadBoundKeymap(w, r, keymapName)
+	if !ok {
+		return
+	}
+	writeKeymapSource(w, keymapName, parser.FormatKeymap(source, layout))
+}
+
+// loadKeymapSource reads the .keymap file a keymap was uploaded as. On
+// failure it writes the error response and returns false.
+func loadKeymapSource(w http.ResponseWriter, keymapName string) (string, bool) {
+	source, err := os.ReadFile(filepath.Join(keymapsDir, keymapName+".keymap"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Keymap source not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read keymap source", http.StatusInternalServerError)
+		}
+		return "", false
+	}
+	return string(source), true
+}
+
+// writeKeymapSource writes a .keymap file as a download
+func writeKeymapSource(w http.ResponseWriter, keymapName, source string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+keymapName+`.keymap"`)
+	w.Write([]byte(source))
+}
+
+// layerList reads the layers=0,Nav,... parameter, layers given by index or
+// name. On an unknown layer it writes the error response and returns false.
+func layerList(w http.ResponseWriter, r *http.Request, keymap *parser.Keymap) ([]int, bool) {
+	var layers []int
+	if list := r.URL.Query().Get("layers"); list != "" {
+		for _, name := range strings.Split(list, ",") {
+			i := findLayer(keymap, strings.TrimSpace(name))
+			if i < 0 {
+				http.Error(w, "Layer not found: "+name, http.StatusNotFound)
+				return nil, false
+			}
+			layers = append(layers, i)
+		}
+	}
+	return layers, true
+}
+
+// imageFile splits a requested image file name into its base and format. On
+// an unsupported format it writes the error response and returns false.
+func imageFile(w http.ResponseWriter, file string) (base, format string, ok bool) {
+	dot := strings.LastIndex(file, ".")
+	if dot < 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return "", "", false
+	}
+	base, format = file[:dot], file[dot+1:]
+	if format != "svg" && format != "png" && format != "txt" {
+		http.Error(w, "Unsupported image format", http.StatusBadRequest)
+		return "", "", false
+	}
+	return base, format, true
+}
+
+// writeImage writes scenes, stacked, as an image in the given format. PNGs
+// are drawn at scale=N times the SVG size (default 1); text is drawn with
+// style=ascii|box borders (default box), a single scene without its title.
+func writeImage(w http.ResponseWriter, r *http.Request, format string, scenes []*render.Scene) {
+	switch format {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(render.StackedSVG(scenes))
+
+	case "png":
+		scale := 1.0
+		if s := r.URL.Query().Get("scale"); s != "" {
+			var err error
+			scale, err = strconv.ParseFloat(s, 64)
+			if err != nil || scale <= 0 || scale > render.MaxScale {
+				http.Error(w, "Invalid scale", http.StatusBadRequest)
+				return
+			}
+		}
+		data, err := render.PNG(scenes, scale)
+		if err != nil {
+			http.Error(w, "Failed to render image: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+
+	case "txt":
+		style := r.URL.Query().Get("style")
+		var text string
+		var err error
+		if len(scenes) == 1 {
+			text, err = render.Text(scenes[0], style)
+		} else {
+			text, err = render.StackedText(scenes, style)
+		}
+		if err != nil {
+			http.Error(w, "Failed to render text: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(text))
+	}
+}
+
+// loadBoundKeymap loads a keymap and the layout it is drawn on, with the key
+// indices of the layout bound to the keymap's bindings. On failure it writes
+// the error response and returns false.
+func loadBoundKeymap(w http.ResponseWriter, r *http.Request, keymapName string) (*parser.Keymap, *parser.Layout, bool) {
+	keymap, err := loadKeymap(keymapName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Keymap not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read keymap", http.StatusInternalServerError)
+		}
+		return nil, nil, false
+	}
+
+	layout, layoutName, _ := keymapLayout(keymap)
+	if layout == nil {
+		http.Error(w, "Keymap has no layout", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	layout, err = layoutVariant(layout, r)
+	if err != nil {
+		http.Error(w, "Invalid variant: "+err.Error(), http.StatusBadRequest)
+		return nil, nil, false
+	}
+	return keymap, parser.BindLayout(layout, joinKeymap(layoutName, layout, keymap)), true
+}
+
+// findLayer returns the index of a layer given by index or by name, or -1
+func findLayer(keymap *parser.Keymap, layer string) int {
+	if i, err := strconv.Atoi(layer); err == nil {
+		if i >= 0 && i < len(keymap.Layers) {
+			return i
+		}
+		return -1
+	}
+	for i, l := range keymap.Layers {
+		if l.Name == layer {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"keyviewer/internal/parser"
+)
+
+// layoutTransform is the body of a POST to /api/layout/{name}/transform
+type layoutTransform struct {
+	Op       string          `json:"op"`       // "mirror", "split", "merge", "rotate", "renumber", "insert" or "remove"
+	Name     string          `json:"name"`     // Name of the new layout, "{name}-{op}" by default
+	Axis     *float64        `json:"axis"`     // mirror, split: x of the vertical axis, the middle of the layout by default
+	Side     string          `json:"side"`     // split: "left" (default) or "right"
+	With     string          `json:"with"`     // merge: layout placed on the right, a mirrored copy of this one by default
+	Gap      float64         `json:"gap"`      // merge: space between the two halves in key units
+	Keys     []int           `json:"keys"`     // rotate, remove: positions in the layout's key list
+	Angle    float64         `json:"angle"`    // rotate: degrees clockwise
+	Origin   *parser.Point   `json:"origin"`   // rotate: center of rotation, the middle of the keys by default
+	Key      json.RawMessage `json:"key"`      // insert: the new key, numbered after the last key when it has no index
+	Renumber bool            `json:"renumber"` // Renumber keys by position afterwards
+}
+
+// handleLayoutTransform handles POST /api/layout/{name}/transform, which
+// applies a geometric operation to a layout and stores the result as a new layout.
+// The name is made a file name like those of uploaded layouts; an existing
+// layout of that name is only replaced with ?overwrite=1.
+func handleLayoutTransform(w http.ResponseWriter, r *http.Request, layoutName string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req layoutTransform
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	layout, err := loadLayout(layoutName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Layout not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	extent := parser.ComputeGeometry(layout).Extent
+	axis := extent.X + extent.W/2
+	if req.Axis != nil {
+		axis = *req.Axis
+	}
+
+	var result *parser.Layout
+	switch req.Op {
+	case "mirror":
+		result = parser.MirrorLayout(layout, axis)
+
+	case "split":
+		if req.Side != "" && req.Side != "left" && req.Side != "right" {
+			http.Error(w, "Side must be left or right", http.StatusBadRequest)
+			return
+		}
+		result = parser.SplitLayout(layout, axis, req.Side == "right")
+
+	case "merge":
+		right := parser.MirrorLayout(layout, axis)
+		if req.With != "" {
+			right, err = loadLayout(req.With)
+			if err != nil {
+				if os.IsNotExist(err) {
+					http.Error(w, "Layout not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Failed to read layout", http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+		result = parser.MergeLayouts(layout, right, req.Gap)
+
+	case "rotate":
+		if len(req.Keys) == 0 {
+			http.Error(w, "Keys to rotate required", http.StatusBadRequest)
+			return
+		}
+		var origin parser.Point
+		if req.Origin != nil {
+			origin = *req.Origin
+		} else {
+			var selected []parser.PhysicalKey
+			for _, i := range req.Keys {
+				if i >= 0 && i < len(layout.Keys) {
+					selected = append(selected, layout.Keys[i])
+				}
+			}
+			bounds := parser.ComputeGeometry(&parser.Layout{Keys: selected}).Extent
+			origin = parser.Point{X: bounds.X + bounds.W/2, Y: bounds.Y + bounds.H/2}
+		}
+		result, err = parser.RotateKeys(layout, req.Keys, req.Angle, origin)
+
+	case "renumber":
+		result = parser.RenumberLayout(layout)
+
+	case "insert":
+		key := parser.PhysicalKey{W: 1, H: 1, Index: -1}
+		if len(req.Key) == 0 || json.Unmarshal(req.Key, &key) != nil {
+			http.Error(w, "Key to insert required", http.StatusBadRequest)
+			return
+		}
+		result = parser.InsertKey(layout, key)
+
+	case "remove":
+		result, err = parser.RemoveKeys(layout, req.Keys)
+
+	default:
+		http.Error(w, "Unsupported transform operation", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to transform layout: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Renumber {
+		result = parser.RenumberLayout(result)
+	}
+
+	result.Name = req.Name
+	if result.Name == "" {
+		result.Name = layoutName + "-" + req.Op
+	}
+	if result.Name = slugName(result.Name); result.Name == "" {
+		http.Error(w, "Invalid layout name", http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("overwrite") != "1" {
+		if _, err := os.Stat(filepath.Join(layoutsDir, result.Name+".json")); err == nil {
+			http.Error(w, "Layout already exists", http.StatusConflict)
+			return
+		}
+	}
+	if err := saveLayout(result, result.Name); err != nil {
+		http.Error(w, "Failed to save layout", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Layout   *parser.Layout         `json:"layout"`
+		Warnings []parser.LayoutWarning `json:"warnings"`
+	}{result, parser.ValidateLayout(result)})
+}
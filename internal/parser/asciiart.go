@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"strings"
+)
+
+// ArtCell is a key cell of the ASCII art drawn in keymap comments, positioned
+// by its middle column and line
+type ArtCell struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// isArtSeparator reports whether r separates cells horizontally
+func isArtSeparator(r rune) bool {
+	return r == '|' || r == '│' || r == '┃' || r == '║'
+}
+
+// isArtBorder reports whether r can be part of a border line above or below a cell
+func isArtBorder(r rune) bool {
+	return r == '-' || r == '=' || r == '+' || r == '_' || (r >= 0x2500 && r <= 0x257F)
+}
+
+// artCommentBlocks returns the runs of consecutive comment lines in a source file
+func artCommentBlocks(content string) [][][]rune {
+	var blocks [][][]rune
+	var block [][]rune
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		isComment := inBlock || strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "/*")
+		if strings.Contains(line, "/*") {
+			inBlock = true
+		}
+		if strings.Contains(line, "*/") {
+			inBlock = false
+		}
+
+		if isComment {
+			block = append(block, []rune(line))
+		} else if len(block) > 0 {
+			blocks = append(blocks, block)
+			block = nil
+		}
+	}
+	if len(block) > 0 {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// isArtBorderLine reports whether a comment line is a border line of a
+// diagram: only border and separator characters besides the comment markers
+func isArtBorderLine(line []rune) bool {
+	text := strings.TrimSpace(string(line))
+	text = strings.TrimPrefix(text, "//")
+	text = strings.TrimPrefix(text, "/*")
+	text = strings.TrimSuffix(text, "*/")
+	text = strings.TrimLeft(text, "* ")
+	borders := 0
+	for _, r := range text {
+		switch {
+		case isArtBorder(r):
+			borders++
+		case !isArtSeparator(r) && r != ' ':
+			return false
+		}
+	}
+	return borders >= 3
+}
+
+// artCells finds the key cells of a block of art in reading order. A cell is
+// the space between two separators with a border line directly above or
+// below it; cells continuing the cell on the line above belong to that key.
+// Art without any border lines has one key per row of separators, so there
+// every span holding a legend is a cell and empty spans are gaps.
+func artCells(lines [][]rune) []ArtCell {
+	borderAt := func(line, col int) bool {
+		return line >= 0 && line < len(lines) && col < len(lines[line]) && isArtBorder(lines[line][col])
+	}
+	bordered := false
+	for _, line := range lines {
+		if isArtBorderLine(line) {
+			bordered = true
+		}
+	}
+
+	var cells []ArtCell
+	prevSpans := map[[2]int]bool{}
+	for l, line := range lines {
+		spans := map[[2]int]bool{}
+		last := -1
+		for c, r := range line {
+			if !isArtSeparator(r) {
+				continue
+			}
+			if last >= 0 && c-last >= 2 {
+				span := [2]int{last, c}
+				mid := (last + c) / 2
+				spans[span] = true
+				if !bordered {
+					if strings.TrimSpace(string(line[last+1:c])) != "" {
+						cells = append(cells, ArtCell{X: float64(last+c) / 2, Y: float64(l)})
+					}
+					last = c
+					continue
+				}
+				continued := prevSpans[span] && !borderAt(l-1, mid)
+				if !continued && (borderAt(l-1, mid) || borderAt(l+1, mid)) {
+					cells = append(cells, ArtCell{X: float64(last+c) / 2, Y: float64(l)})
+				}
+			}
+			last = c
+		}
+		prevSpans = spans
+	}
+	return cells
+}
+
+// KeymapArt finds the ASCII art diagram in the comments of a keymap source and
+// returns its key cells in reading order. With several diagrams, the first
+// one with bindingCount cells is used, or else the closest in size.
+func KeymapArt(content string, bindingCount int) []ArtCell {
+	var best []ArtCell
+	bestDiff := -1
+	for _, block := range artCommentBlocks(content) {
+		cells := artCells(block)
+		if len(cells) == 0 {
+			continue
+		}
+		diff := len(cells) - bindingCount
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff == -1 || diff < bestDiff {
+			best, bestDiff = cells, diff
+		}
+		if diff == 0 {
+			break
+		}
+	}
+	return best
+}
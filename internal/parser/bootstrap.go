@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// UnmappedLegend is a layout legend that could not be turned into a keycode
+type UnmappedLegend struct {
+	Index  int    `json:"index"`
+	Legend string `json:"legend"`
+}
+
+// legendKeycodes maps common KLE legend spellings (lowercase) to ZMK keycodes
+var legendKeycodes = map[string]string{
+	"esc":          "ESC",
+	"escape":       "ESC",
+	"bksp":         "BSPC",
+	"bkspc":        "BSPC",
+	"bspc":         "BSPC",
+	"backspace":    "BSPC",
+	"back space":   "BSPC",
+	"⌫":            "BSPC",
+	"tab":          "TAB",
+	"⇥":            "TAB",
+	"caps":         "CAPS",
+	"caps lock":    "CAPS",
+	"capslock":     "CAPS",
+	"⇪":            "CAPS",
+	"enter":        "ENTER",
+	"ent":          "ENTER",
+	"return":       "ENTER",
+	"ret":          "ENTER",
+	"⏎":            "ENTER",
+	"↵":            "ENTER",
+	"spc":          "SPACE",
+	"space":        "SPACE",
+	"del":          "DEL",
+	"delete":       "DEL",
+	"⌦":            "DEL",
+	"ins":          "INSERT",
+	"insert":       "INSERT",
+	"home":         "HOME",
+	"end":          "END",
+	"pgup":         "PG_UP",
+	"pg up":        "PG_UP",
+	"page up":      "PG_UP",
+	"pgdn":         "PG_DN",
+	"pg dn":        "PG_DN",
+	"page down":    "PG_DN",
+	"shft":         "LSHIFT",
+	"shift":        "LSHIFT",
+	"⇧":            "LSHIFT",
+	"ctrl":         "LCTRL",
+	"ctl":          "LCTRL",
+	"control":      "LCTRL",
+	"⌃":            "LCTRL",
+	"alt":          "LALT",
+	"opt":          "LALT",
+	"option":       "LALT",
+	"⌥":            "LALT",
+	"altgr":        "RALT",
+	"alt gr":       "RALT",
+	"gui":          "LGUI",
+	"win":          "LGUI",
+	"cmd":          "LGUI",
+	"command":      "LGUI",
+	"super":        "LGUI",
+	"meta":         "LGUI",
+	"⌘":            "LGUI",
+	"menu":         "K_APP",
+	"app":          "K_APP",
+	"psc":          "PSCRN",
+	"prtsc":        "PSCRN",
+	"prt sc":       "PSCRN",
+	"print screen": "PSCRN",
+	"slk":          "SLCK",
+	"scrlk":        "SLCK",
+	"scroll lock":  "SLCK",
+	"pau":          "PAUSE_BREAK",
+	"pause":        "PAUSE_BREAK",
+	"break":        "PAUSE_BREAK",
+	"←":            "LEFT",
+	"left":         "LEFT",
+	"→":            "RIGHT",
+	"right":        "RIGHT",
+	"↑":            "UP",
+	"up":           "UP",
+	"↓":            "DOWN",
+	"down":         "DOWN",
+	"`":            "GRAVE",
+	"~":            "GRAVE",
+	"-":            "MINUS",
+	"_":            "MINUS",
+	"=":            "EQUAL",
+	"+":            "EQUAL",
+	"[":            "LBKT",
+	"{":            "LBKT",
+	"]":            "RBKT",
+	"}":            "RBKT",
+	"\\":           "BSLH",
+	"|":            "BSLH",
+	";":            "SEMI",
+	":":            "SEMI",
+	"'":            "SQT",
+	"\"":           "SQT",
+	",":            "COMMA",
+	"<":            "COMMA",
+	".":            "DOT",
+	">":            "DOT",
+	"/":            "SLASH",
+	"?":            "SLASH",
+}
+
+// GuessKeycode guesses the ZMK keycode printed on a key from its legend text,
+// e.g. "Bksp" -> BSPC, "Shft" -> LSHIFT, "7" -> N7
+func GuessKeycode(legend string) (string, bool) {
+	text := strings.ToLower(strings.TrimSpace(legend))
+	if text == "" {
+		return "", false
+	}
+	if code, ok := legendKeycodes[text]; ok {
+		return code, true
+	}
+
+	// Single letters and digits
+	if utf8.RuneCountInString(text) == 1 {
+		c := text[0]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return strings.ToUpper(text), true
+		case c >= '0' && c <= '9':
+			return "N" + text, true
+		}
+	}
+
+	// Function keys F1..F24
+	if len(text) >= 2 && len(text) <= 3 && text[0] == 'f' {
+		n := 0
+		for _, c := range text[1:] {
+			if c < '0' || c > '9' {
+				return "", false
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n >= 1 && n <= 24 {
+			return strings.ToUpper(text), true
+		}
+	}
+
+	return "", false
+}
+
+// KeymapFromLayout builds a starter keymap from the legends of a layout: a single
+// base layer of &kp bindings, one per indexed key. Keys whose legends can't be
+// mapped get &none and are reported. The keymap doesn't carry the layout;
+// callers attach the layout or a reference to it.
+func KeymapFromLayout(layout *Layout, name string) (*Keymap, []UnmappedLegend) {
+	count := 0
+	for _, key := range layout.Keys {
+		if key.Index >= count {
+			count = key.Index + 1
+		}
+	}
+
+	layer := Layer{
+		Name:        "Base",
+		Keys:        make([]string, count),
+		CustomNames: make(map[string]string),
+		Bindings:    make([]Binding, count),
+	}
+	for i := range layer.Bindings {
+		layer.Bindings[i] = Binding{Behavior: "&none"}
+	}
+
+	unmapped := []UnmappedLegend{}
+	for _, key := range layout.Keys {
+		if key.Index < 0 {
+			continue
+		}
+
+		// Try every legend on the key, most prominent first, so "!" over "1" still maps to N1
+		code := ""
+		for _, slot := range legendSlotOrder {
+			if slot >= len(key.Labels) {
+				continue
+			}
+			if guess, ok := GuessKeycode(key.Labels[slot]); ok {
+				code = guess
+				break
+			}
+		}
+
+		if code == "" {
+			if legend := key.PrimaryLabel(); legend != "" {
+				unmapped = append(unmapped, UnmappedLegend{Index: key.Index, Legend: legend})
+			}
+			continue
+		}
+
+		binding := Binding{Behavior: "&kp", Params: []string{code}}
+		layer.Bindings[key.Index] = binding
+		layer.Keys[key.Index] = convertBinding(binding.String())
+	}
+
+	return &Keymap{
+		Name:   name,
+		Layers: []Layer{layer},
+		Format: FormatZMK,
+	}, unmapped
+}
@@ -0,0 +1,254 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dtNode is a devicetree node with its raw property values
+type dtNode struct {
+	Label    string            // Label before the colon, e.g. "default_layout" in "default_layout: layout_0 { ... }"
+	Name     string            // Node name, "/" for the root and "&label" for reference overrides
+	Props    map[string]string // Property name -> raw value text (empty for boolean properties)
+	Children []*dtNode
+}
+
+// parseDeviceTree parses devicetree source (.dts, .dtsi, .overlay, .keymap)
+// into its top-level nodes. Comments and preprocessor lines are dropped;
+// macros are left unexpanded.
+func parseDeviceTree(src string) []*dtNode {
+	_, children := parseDTBlock(stripDTComments(src))
+	return children
+}
+
+// stripDTComments removes C comments and preprocessor directives, keeping string literals intact
+func stripDTComments(src string) string {
+	var b strings.Builder
+	inString := false
+	lineStart := true
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case inString:
+			b.WriteByte(c)
+			if c == '\\' && i+1 < len(src) {
+				i++
+				b.WriteByte(src[i])
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+			b.WriteByte(c)
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			b.WriteByte('\n')
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return b.String()
+			}
+			i += end + 3
+			b.WriteByte(' ')
+		case c == '#' && lineStart:
+			// Preprocessor directive, honoring line continuations
+			for i < len(src) && !(src[i] == '\n' && src[i-1] != '\\') {
+				i++
+			}
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(c)
+		}
+
+		if c == '\n' {
+			lineStart = true
+		} else if c != ' ' && c != '\t' {
+			lineStart = false
+		}
+	}
+	return b.String()
+}
+
+// parseDTBlock parses the statements of a node body: properties and child nodes
+func parseDTBlock(text string) (map[string]string, []*dtNode) {
+	props := make(map[string]string)
+	var children []*dtNode
+
+	i := 0
+	for i < len(text) {
+		// Find the end of the statement: ';' for a property, '{' for a child node
+		j := i
+		inString := false
+		for j < len(text) {
+			c := text[j]
+			if inString {
+				if c == '\\' {
+					j++
+				} else if c == '"' {
+					inString = false
+				}
+			} else if c == '"' {
+				inString = true
+			} else if c == ';' || c == '{' {
+				break
+			}
+			j++
+		}
+		if j >= len(text) {
+			break
+		}
+
+		statement := strings.TrimSpace(text[i:j])
+		if text[j] == ';' {
+			if statement != "" {
+				name, value, _ := strings.Cut(statement, "=")
+				props[strings.TrimSpace(name)] = strings.TrimSpace(value)
+			}
+			i = j + 1
+			continue
+		}
+
+		end := findMatchingBrace(text, j)
+		if end == -1 {
+			break
+		}
+		node := &dtNode{Name: statement}
+		if label, name, ok := strings.Cut(statement, ":"); ok && !strings.HasPrefix(statement, "&") {
+			node.Label = strings.TrimSpace(label)
+			node.Name = strings.TrimSpace(name)
+		}
+		node.Props, node.Children = parseDTBlock(text[j+1 : end])
+		children = append(children, node)
+		i = end + 1
+	}
+
+	return props, children
+}
+
+// findMatchingBrace finds the index of the brace closing the one at startIdx
+func findMatchingBrace(s string, startIdx int) int {
+	depth := 0
+	for i := startIdx; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// walkDT calls fn for every node in the tree, depth first
+func walkDT(nodes []*dtNode, fn func(*dtNode)) {
+	for _, n := range nodes {
+		fn(n)
+		walkDT(n.Children, fn)
+	}
+}
+
+// findDTLabel returns the node with the given label
+func findDTLabel(nodes []*dtNode, label string) *dtNode {
+	var found *dtNode
+	walkDT(nodes, func(n *dtNode) {
+		if found == nil && n.Label == label {
+			found = n
+		}
+	})
+	return found
+}
+
+// dtString returns the value of a string property without its quotes
+func dtString(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"`)
+}
+
+// dtCells returns the cells of a property value, joining every <...> group
+func dtCells(value string) []string {
+	var cells []string
+	for _, group := range regexp.MustCompile(`<([^>]*)>`).FindAllStringSubmatch(value, -1) {
+		cells = append(cells, splitDTCells(group[1])...)
+	}
+	return cells
+}
+
+// splitDTCells splits cell text on whitespace, keeping parenthesized expressions together
+func splitDTCells(text string) []string {
+	var cells []string
+	depth := 0
+	start := -1
+	for i, c := range text {
+		switch {
+		case c == '(':
+			if start == -1 {
+				start = i
+			}
+			depth++
+		case c == ')':
+			depth--
+		case (c == ' ' || c == '\t' || c == '\n' || c == '\r') && depth == 0:
+			if start != -1 {
+				cells = append(cells, text[start:i])
+				start = -1
+			}
+			continue
+		default:
+			if start == -1 {
+				start = i
+			}
+		}
+	}
+	if start != -1 {
+		cells = append(cells, text[start:])
+	}
+	return cells
+}
+
+// dtInt evaluates an integer cell such as "100", "(-3000)" or "0x10"
+func dtInt(cell string) (int, bool) {
+	cell = strings.TrimSpace(cell)
+	for strings.HasPrefix(cell, "(") && strings.HasSuffix(cell, ")") {
+		cell = strings.TrimSpace(cell[1 : len(cell)-1])
+	}
+	n, err := strconv.ParseInt(cell, 0, 64)
+	if err != nil {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// parseMatrixTransform reads the RC(row, col) entries of a zmk,matrix-transform node's map
+func parseMatrixTransform(node *dtNode) [][]int {
+	var positions [][]int
+	rc := regexp.MustCompile(`RC\(\s*(\d+)\s*,\s*(\d+)\s*\)`)
+	for _, m := range rc.FindAllStringSubmatch(node.Props["map"], -1) {
+		row, _ := strconv.Atoi(m[1])
+		col, _ := strconv.Atoi(m[2])
+		positions = append(positions, []int{row, col})
+	}
+	return positions
+}
+
+// applyDTOverrides merges the properties of "&label { ... };" reference nodes
+// into the labeled nodes they extend, as the devicetree compiler does
+func applyDTOverrides(nodes []*dtNode) {
+	for _, n := range nodes {
+		if !strings.HasPrefix(n.Name, "&") {
+			continue
+		}
+		target := findDTLabel(nodes, strings.TrimPrefix(n.Name, "&"))
+		if target == nil {
+			continue
+		}
+		for k, v := range n.Props {
+			target.Props[k] = v
+		}
+		target.Children = append(target.Children, n.Children...)
+	}
+}
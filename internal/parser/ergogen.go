@@ -0,0 +1,861 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ergoMap is a YAML mapping that keeps its key order, which Ergogen relies on
+// for the order of zones, columns and rows
+type ergoMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newErgoMap() *ergoMap {
+	return &ergoMap{values: make(map[string]interface{})}
+}
+
+func (m *ergoMap) get(key string) interface{} {
+	if m == nil {
+		return nil
+	}
+	return m.values[key]
+}
+
+func (m *ergoMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *ergoMap) delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// ergoMapOf returns v as a mapping, treating null as empty
+func ergoMapOf(v interface{}) *ergoMap {
+	if m, ok := v.(*ergoMap); ok {
+		return m
+	}
+	return newErgoMap()
+}
+
+// ergoRowsOf returns a map of rows, with rows left empty in YAML as empty maps
+func ergoRowsOf(v interface{}) *ergoMap {
+	rows := newErgoMap()
+	m := ergoMapOf(v)
+	for _, k := range m.keys {
+		rows.set(k, ergoMapOf(m.values[k]))
+	}
+	return rows
+}
+
+// ergogenValue converts a YAML node to maps, lists and scalars. Dotted keys
+// such as "key.stagger" are expanded into nested maps, like Ergogen's unnest.
+func ergogenValue(n *yaml.Node) interface{} {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil
+		}
+		return ergogenValue(n.Content[0])
+	case yaml.AliasNode:
+		return ergogenValue(n.Alias)
+	case yaml.MappingNode:
+		m := newErgoMap()
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i].Value, ergogenValue(n.Content[i+1])
+			if key == "<<" {
+				for _, k := range ergoMapOf(value).keys {
+					m.set(k, ergoMapOf(value).values[k])
+				}
+				continue
+			}
+			path := strings.Split(key, ".")
+			for j := len(path) - 1; j > 0; j-- {
+				nested := newErgoMap()
+				nested.set(path[j], value)
+				value = nested
+			}
+			m.set(path[0], ergoExtend(m.get(path[0]), value))
+		}
+		return m
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(n.Content))
+		for i, item := range n.Content {
+			list[i] = ergogenValue(item)
+		}
+		return list
+	default:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return n.Value
+		}
+		if i, ok := v.(int); ok {
+			return float64(i)
+		}
+		return v
+	}
+}
+
+// ergoExtend deep-merges from over to, as Ergogen's prep.extend does: maps
+// merge key by key, "$unset" removes a value and null keeps the original.
+// Values are shared, not copied, so neither argument may be modified afterwards.
+func ergoExtend(to, from interface{}) interface{} {
+	if from == nil {
+		return to
+	}
+	if s, ok := from.(string); ok && s == "$unset" {
+		return nil
+	}
+
+	switch f := from.(type) {
+	case *ergoMap:
+		t, ok := to.(*ergoMap)
+		if !ok {
+			return f
+		}
+		res := newErgoMap()
+		for _, k := range t.keys {
+			res.set(k, t.values[k])
+		}
+		for _, k := range f.keys {
+			if merged := ergoExtend(res.get(k), f.values[k]); merged != nil {
+				res.set(k, merged)
+			} else {
+				res.delete(k)
+			}
+		}
+		return res
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok {
+			return f
+		}
+		res := append([]interface{}{}, t...)
+		for i, v := range f {
+			if i < len(res) {
+				res[i] = ergoExtend(res[i], v)
+			} else {
+				res = append(res, v)
+			}
+		}
+		return res
+	default:
+		return from
+	}
+}
+
+// ergoPoint is a point in Ergogen's coordinate system: millimeters with y
+// pointing up and counterclockwise rotation in degrees
+type ergoPoint struct {
+	x, y, r float64
+	meta    ergoMeta
+}
+
+// ergoMeta is the part of a key's configuration that survives into the layout
+type ergoMeta struct {
+	name          string
+	width, height float64
+	asym          string
+	skip          bool
+	mirrored      bool
+}
+
+// rotateErgo rotates p counterclockwise by angle degrees around origin
+func rotateErgo(p [2]float64, angle float64, origin [2]float64) [2]float64 {
+	rad := angle * math.Pi / 180
+	dx, dy := p[0]-origin[0], p[1]-origin[1]
+	return [2]float64{
+		origin[0] + dx*math.Cos(rad) - dy*math.Sin(rad),
+		origin[1] + dx*math.Sin(rad) + dy*math.Cos(rad),
+	}
+}
+
+// shift moves the point, relative to its own rotation unless relative is false.
+// Mirrored points move the other way along x unless resist is set.
+func (p *ergoPoint) shift(s [2]float64, relative, resist bool) {
+	if !resist && p.meta.mirrored {
+		s[0] = -s[0]
+	}
+	if relative {
+		s = rotateErgo(s, p.r, [2]float64{})
+	}
+	p.x += s[0]
+	p.y += s[1]
+}
+
+// rotate turns the point by angle degrees, around origin when one is given
+func (p *ergoPoint) rotate(angle float64, origin *[2]float64, resist bool) {
+	if !resist && p.meta.mirrored {
+		angle = -angle
+	}
+	if origin != nil {
+		pos := rotateErgo([2]float64{p.x, p.y}, angle, *origin)
+		p.x, p.y = pos[0], pos[1]
+	}
+	p.r += angle
+}
+
+// mirror reflects the point across the vertical line at x = axis
+func (p *ergoPoint) mirror(axis float64) {
+	p.x = 2*axis - p.x
+	p.r = -p.r
+}
+
+// ergogen evaluates the points section of an Ergogen config
+type ergogen struct {
+	units  map[string]float64
+	points map[string]*ergoPoint
+	order  []string
+	err    error
+}
+
+// fail records the first error; evaluation carries on with zero values
+func (e *ergogen) fail(format string, args ...interface{}) {
+	if e.err == nil {
+		e.err = fmt.Errorf(format, args...)
+	}
+}
+
+// num evaluates a number or a units expression such as "u-1" or "-0.5cy"
+func (e *ergogen) num(v interface{}, name string) float64 {
+	switch n := v.(type) {
+	case nil:
+		return 0
+	case float64:
+		return n
+	case string:
+		f, err := evalErgogenExpr(n, e.units)
+		if err != nil {
+			e.fail("%s: %v", name, err)
+		}
+		return f
+	default:
+		e.fail("%s: expected a number, got %v", name, v)
+		return 0
+	}
+}
+
+// xy evaluates a single number (used for both coordinates) or an [x, y] pair
+func (e *ergogen) xy(v interface{}, name string) [2]float64 {
+	if list, ok := v.([]interface{}); ok {
+		if len(list) != 2 {
+			e.fail("%s: expected an [x, y] pair", name)
+			return [2]float64{}
+		}
+		return [2]float64{e.num(list[0], name), e.num(list[1], name)}
+	}
+	n := e.num(v, name)
+	return [2]float64{n, n}
+}
+
+// ergogenDefaultUnits are the units Ergogen predefines
+var ergogenDefaultUnits = []struct {
+	name  string
+	value string
+}{
+	{"U", "19.05"},
+	{"u", "19"},
+	{"cx", "18"},
+	{"cy", "17"},
+	{"$default_stagger", "0"},
+	{"$default_spread", "u"},
+	{"$default_splay", "0"},
+	{"$default_height", "u-1"},
+	{"$default_width", "u-1"},
+	{"$default_padding", "u"},
+	{"$default_autobind", "10"},
+}
+
+// IsErgogenConfig reports whether data is an Ergogen config with a points section
+func IsErgogenConfig(data []byte) bool {
+	var doc struct {
+		Points struct {
+			Zones yaml.Node `yaml:"zones"`
+		} `yaml:"points"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	return doc.Points.Zones.Kind == yaml.MappingNode
+}
+
+// ParseErgogenPoints evaluates the points section of an Ergogen config (zones
+// with their columns and rows, stagger, splay, spread, rotate and mirror) into
+// a Layout. Ergogen works in millimeters with y pointing up; positions are
+// converted to key units of u (19mm by default) with y pointing down.
+func ParseErgogenPoints(data []byte, name string) (*Layout, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	config := ergoMapOf(ergogenValue(&root))
+	points, ok := config.get("points").(*ergoMap)
+	if !ok {
+		return nil, fmt.Errorf("config has no points section")
+	}
+	zones, ok := points.get("zones").(*ergoMap)
+	if !ok || len(zones.keys) == 0 {
+		return nil, fmt.Errorf("config has no zones")
+	}
+
+	e := &ergogen{
+		units:  make(map[string]float64),
+		points: make(map[string]*ergoPoint),
+	}
+
+	// Units and variables override the defaults and are evaluated in order, so
+	// each can use the ones before it
+	units := newErgoMap()
+	for _, u := range ergogenDefaultUnits {
+		units.set(u.name, u.value)
+	}
+	units = ergoMapOf(ergoExtend(units, config.get("units")))
+	units = ergoMapOf(ergoExtend(units, config.get("variables")))
+	for _, k := range units.keys {
+		e.units[k] = e.num(units.values[k], "units."+k)
+	}
+
+	globalKey := ergoMapOf(points.get("key"))
+	for _, zoneName := range zones.keys {
+		zone := ergoMapOf(zones.values[zoneName])
+		anchor := e.anchor(zone.get("anchor"), "points.zones."+zoneName+".anchor", ergoPoint{})
+		newPoints := e.renderZone(zoneName, zone, anchor, globalKey)
+
+		// Single-key columns and zones without rows or columns drop their
+		// "_default" suffixes, so matrix_a_default is matrix_a
+		for _, p := range newPoints {
+			for strings.HasSuffix(p.meta.name, "_default") {
+				p.meta.name = strings.TrimSuffix(p.meta.name, "_default")
+			}
+		}
+
+		if rotate := e.num(zone.get("rotate"), "points.zones."+zoneName+".rotate"); rotate != 0 {
+			for _, p := range newPoints {
+				p.rotate(rotate, &[2]float64{}, false)
+			}
+		}
+		if axis, ok := e.mirrorAxis(zone.get("mirror"), "points.zones."+zoneName+".mirror"); ok {
+			newPoints = append(newPoints, mirrorErgoPoints(newPoints, axis)...)
+		}
+		e.add(newPoints)
+	}
+
+	if rotate := e.num(points.get("rotate"), "points.rotate"); rotate != 0 {
+		for _, n := range e.order {
+			e.points[n].rotate(rotate, &[2]float64{}, false)
+		}
+	}
+	if axis, ok := e.mirrorAxis(points.get("mirror"), "points.mirror"); ok {
+		all := make([]*ergoPoint, len(e.order))
+		for i, n := range e.order {
+			all[i] = e.points[n]
+		}
+		e.add(mirrorErgoPoints(all, axis))
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	layout := &Layout{
+		Name: name,
+		Keys: []PhysicalKey{},
+	}
+
+	// Ergogen sizes exclude the 1mm gap between keycaps (u-1), key units include it
+	unit := e.units["u"]
+	for _, n := range e.order {
+		p := e.points[n]
+		if p.meta.skip {
+			continue
+		}
+		w := (p.meta.width + 1) / unit
+		h := (p.meta.height + 1) / unit
+		cx, cy := p.x/unit, -p.y/unit
+		layout.Keys = append(layout.Keys, PhysicalKey{
+			X:     cx - w/2,
+			Y:     cy - h/2,
+			W:     w,
+			H:     h,
+			R:     -p.r,
+			RX:    cx,
+			RY:    cy,
+			Index: len(layout.Keys),
+		})
+	}
+	if len(layout.Keys) == 0 {
+		return nil, fmt.Errorf("points section has no keys")
+	}
+	normalizeErgogenLayout(layout)
+
+	return layout, nil
+}
+
+// add appends points in order; a point redefined by name keeps its original place
+func (e *ergogen) add(points []*ergoPoint) {
+	for _, p := range points {
+		if _, ok := e.points[p.meta.name]; !ok {
+			e.order = append(e.order, p.meta.name)
+		}
+		e.points[p.meta.name] = p
+	}
+}
+
+// anchor evaluates an Ergogen anchor (ref, aggregate, orient, shift, rotate,
+// affect) starting from start. A list of anchors is applied in sequence.
+func (e *ergogen) anchor(raw interface{}, name string, start ergoPoint) ergoPoint {
+	switch a := raw.(type) {
+	case nil:
+		return start
+	case []interface{}:
+		current := start
+		for i, step := range a {
+			current = e.anchor(step, name+"["+strconv.Itoa(i)+"]", current)
+		}
+		return current
+	case string:
+		m := newErgoMap()
+		m.set("ref", a)
+		raw = m
+	}
+	spec, ok := raw.(*ergoMap)
+	if !ok {
+		e.fail("%s: invalid anchor", name)
+		return start
+	}
+	resist, _ := spec.get("resist").(bool)
+
+	point := start
+	switch ref := spec.get("ref").(type) {
+	case nil:
+	case string:
+		p, ok := e.points[ref]
+		if !ok {
+			e.fail("%s: unknown point %q", name, ref)
+			return start
+		}
+		point = *p
+	default:
+		point = e.anchor(ref, name+".ref", ergoPoint{})
+	}
+
+	if agg, ok := spec.get("aggregate").(*ergoMap); ok {
+		parts, _ := agg.get("parts").([]interface{})
+		if len(parts) > 0 {
+			var sum ergoPoint
+			for i, part := range parts {
+				p := e.anchor(part, name+".aggregate.parts["+strconv.Itoa(i)+"]", ergoPoint{})
+				sum.x += p.x
+				sum.y += p.y
+				sum.r += p.r
+			}
+			n := float64(len(parts))
+			point = ergoPoint{x: sum.x / n, y: sum.y / n, r: sum.r / n}
+		}
+	}
+
+	rotator := func(config interface{}, field string) {
+		switch config.(type) {
+		case float64, string:
+			point.rotate(e.num(config, name+"."+field), nil, resist)
+		default:
+			// Turn towards another anchor
+			target := e.anchor(config, name+"."+field, start)
+			point.r = -math.Atan2(target.x-point.x, target.y-point.y) * 180 / math.Pi
+		}
+	}
+	if orient := spec.get("orient"); orient != nil {
+		rotator(orient, "orient")
+	}
+	if shift := spec.get("shift"); shift != nil {
+		point.shift(e.xy(shift, name+".shift"), true, resist)
+	}
+	if rotate := spec.get("rotate"); rotate != nil {
+		rotator(rotate, "rotate")
+	}
+
+	if affect := spec.get("affect"); affect != nil {
+		var fields []string
+		switch a := affect.(type) {
+		case string:
+			fields = strings.Split(a, "")
+		case []interface{}:
+			for _, f := range a {
+				fields = append(fields, fmt.Sprint(f))
+			}
+		}
+		candidate := point
+		point = start
+		point.meta = candidate.meta
+		for _, f := range fields {
+			switch f {
+			case "x":
+				point.x = candidate.x
+			case "y":
+				point.y = candidate.y
+			case "r":
+				point.r = candidate.r
+			}
+		}
+	}
+
+	return point
+}
+
+// mirrorAxis evaluates a mirror setting: a number is the axis itself, a map is
+// an anchor whose x plus half the distance is the axis
+func (e *ergogen) mirrorAxis(raw interface{}, name string) (float64, bool) {
+	switch m := raw.(type) {
+	case nil:
+		return 0, false
+	case float64, string:
+		return e.num(m, name), true
+	case *ergoMap:
+		distance := e.num(m.get("distance"), name+".distance")
+		spec := newErgoMap()
+		for _, k := range m.keys {
+			if k != "distance" {
+				spec.set(k, m.values[k])
+			}
+		}
+		return e.anchor(spec, name, ergoPoint{}).x + distance/2, true
+	default:
+		e.fail("%s: invalid mirror", name)
+		return 0, false
+	}
+}
+
+// mirrorErgoPoints returns the mirror images of points, named mirror_<name>.
+// Keys with asym "source" are not mirrored, keys with asym "clone" only exist mirrored.
+func mirrorErgoPoints(points []*ergoPoint, axis float64) []*ergoPoint {
+	var mirrored []*ergoPoint
+	for _, p := range points {
+		p.meta.mirrored = false
+		if p.meta.asym == "source" || p.meta.asym == "left" {
+			continue
+		}
+		mp := *p
+		mp.mirror(axis)
+		mp.meta.name = "mirror_" + p.meta.name
+		mp.meta.mirrored = true
+		if p.meta.asym == "clone" || p.meta.asym == "right" {
+			p.meta.skip = true
+		}
+		mirrored = append(mirrored, &mp)
+	}
+	return mirrored
+}
+
+// ergoRotation is a column rotation that applies to every later column of a zone
+type ergoRotation struct {
+	angle  float64
+	origin [2]float64
+}
+
+// ergoKey is a key's configuration after the extension chain
+type ergoKey struct {
+	stagger, spread, splay float64
+	origin, shift          [2]float64
+	orient, rotate         float64
+	padding                float64
+	adjust                 interface{}
+	meta                   ergoMeta
+}
+
+// renderZone lays out the columns and rows of a zone from its anchor
+func (e *ergogen) renderZone(zoneName string, zone *ergoMap, anchor ergoPoint, globalKey *ergoMap) []*ergoPoint {
+	columns := ergoMapOf(zone.get("columns"))
+	rows := ergoRowsOf(zone.get("rows"))
+	zoneKey := ergoMapOf(zone.get("key"))
+	if len(columns.keys) == 0 {
+		columns.set("default", nil)
+	}
+
+	defaultKey := newErgoMap()
+	for _, d := range []struct{ field, unit string }{
+		{"stagger", "$default_stagger"},
+		{"spread", "$default_spread"},
+		{"splay", "$default_splay"},
+		{"width", "$default_width"},
+		{"height", "$default_height"},
+		{"padding", "$default_padding"},
+	} {
+		defaultKey.set(d.field, e.units[d.unit])
+	}
+	defaultKey.set("asym", "both")
+	defaultKey.set("colrow", "{{col.name}}_{{row}}")
+	defaultKey.set("name", "{{zone.name}}_{{colrow}}")
+
+	// The anchor's rotation becomes the zone's first rotation
+	zoneAnchor := anchor
+	rotations := []ergoRotation{{angle: zoneAnchor.r, origin: [2]float64{zoneAnchor.x, zoneAnchor.y}}}
+	zoneAnchor.r = 0
+
+	var points []*ergoPoint
+	for i, colName := range columns.keys {
+		col := ergoMapOf(columns.values[colName])
+		colRows := ergoRowsOf(col.get("rows"))
+		actualRows := ergoMapOf(ergoExtend(rows, colRows)).keys
+		if len(actualRows) == 0 {
+			actualRows = []string{"default"}
+		}
+
+		var keys []ergoKey
+		for _, row := range actualRows {
+			merged := ergoMapOf(ergoExtend(defaultKey, globalKey))
+			for _, layer := range []interface{}{zoneKey, col.get("key"), rows.get(row), colRows.get(row)} {
+				merged = ergoMapOf(ergoExtend(merged, layer))
+			}
+			keys = append(keys, e.key(merged, zoneName, colName, row))
+		}
+
+		if i > 0 {
+			zoneAnchor.x += keys[0].spread
+		}
+		zoneAnchor.y += keys[0].stagger
+		colAnchor := zoneAnchor
+
+		// Splay rotates this column and every one after it
+		if keys[0].splay != 0 {
+			origin := colAnchor
+			origin.shift(keys[0].origin, false, false)
+			candidate := [2]float64{origin.x, origin.y}
+			for _, r := range rotations {
+				candidate = rotateErgo(candidate, r.angle, r.origin)
+			}
+			rotations = append(rotations, ergoRotation{angle: keys[0].splay, origin: candidate})
+		}
+
+		running := colAnchor
+		for _, r := range rotations {
+			origin := r.origin
+			running.rotate(r.angle, &origin, false)
+		}
+		for _, key := range keys {
+			point := running
+			point.r += key.orient
+			point.shift(key.shift, true, false)
+			point.r += key.rotate
+			running = point
+
+			point = e.anchor(key.adjust, "points.zones."+zoneName+".adjust", point)
+			point.meta = key.meta
+			points = append(points, &point)
+
+			running.shift([2]float64{0, key.padding}, true, false)
+		}
+	}
+
+	return points
+}
+
+// key evaluates a merged key configuration
+func (e *ergogen) key(m *ergoMap, zoneName, colName, row string) ergoKey {
+	prefix := "points.zones." + zoneName + ".columns." + colName + "." + row
+	num := func(field string) float64 { return e.num(m.get(field), prefix+"."+field) }
+
+	key := ergoKey{
+		stagger: num("stagger"),
+		spread:  num("spread"),
+		splay:   num("splay"),
+		orient:  num("orient"),
+		rotate:  num("rotate"),
+		padding: num("padding"),
+		adjust:  m.get("adjust"),
+	}
+	if origin := m.get("origin"); origin != nil {
+		key.origin = e.xy(origin, prefix+".origin")
+	}
+	if shift := m.get("shift"); shift != nil {
+		key.shift = e.xy(shift, prefix+".shift")
+	}
+	key.meta.width = num("width")
+	key.meta.height = num("height")
+	key.meta.skip, _ = m.get("skip").(bool)
+	key.meta.asym = fmt.Sprint(m.get("asym"))
+
+	template := func(s string) string {
+		return strings.NewReplacer("{{zone.name}}", zoneName, "{{col.name}}", colName, "{{row}}", row).Replace(s)
+	}
+	colrow := template(fmt.Sprint(m.get("colrow")))
+	key.meta.name = template(strings.ReplaceAll(fmt.Sprint(m.get("name")), "{{colrow}}", colrow))
+
+	return key
+}
+
+// normalizeErgogenLayout moves the layout so its rotated extent starts at 0,0
+func normalizeErgogenLayout(layout *Layout) {
+	extent := ComputeGeometry(layout).Extent
+	round := func(v float64) float64 { return math.Round(v*10000) / 10000 }
+	for i := range layout.Keys {
+		k := &layout.Keys[i]
+		k.X, k.Y = round(k.X-extent.X), round(k.Y-extent.Y)
+		k.RX, k.RY = round(k.RX-extent.X), round(k.RY-extent.Y)
+		k.W, k.H, k.R = round(k.W), round(k.H), round(k.R)
+	}
+}
+
+// evalErgogenExpr evaluates an arithmetic expression over units: numbers,
+// unit names, + - * / ^, parentheses and implicit multiplication ("2u", "0.5 cx")
+func evalErgogenExpr(expr string, units map[string]float64) (float64, error) {
+	p := &exprParser{src: expr, units: units}
+	v, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return 0, fmt.Errorf("unexpected %q in %q", p.src[p.pos:], expr)
+	}
+	return v, nil
+}
+
+// exprParser is a recursive descent parser for units expressions
+type exprParser struct {
+	src   string
+	pos   int
+	units map[string]float64
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) sum() (float64, error) {
+	v, err := p.product()
+	for err == nil {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			break
+		}
+		p.pos++
+		var rhs float64
+		rhs, err = p.product()
+		if op == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) product() (float64, error) {
+	v, err := p.unary()
+	for err == nil {
+		op := p.peek()
+		var rhs float64
+		switch {
+		case op == '*' || op == '/':
+			p.pos++
+			rhs, err = p.unary()
+			if op == '*' {
+				v *= rhs
+			} else {
+				v /= rhs
+			}
+		case op == '(' || op == '$' || op == '_' || unicode.IsLetter(rune(op)):
+			// Implicit multiplication, e.g. "0.5cx" or "2(u-1)"
+			rhs, err = p.power()
+			v *= rhs
+		default:
+			return v, nil
+		}
+	}
+	return v, err
+}
+
+func (p *exprParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.power()
+}
+
+func (p *exprParser) power() (float64, error) {
+	base, err := p.atom()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		exp, err := p.unary()
+		return math.Pow(base, exp), err
+	}
+	return base, nil
+}
+
+func (p *exprParser) atom() (float64, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		v, err := p.sum()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing ) in %q", p.src)
+		}
+		p.pos++
+		return v, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	case c == '$' || c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) {
+			r := rune(p.src[p.pos])
+			if r != '$' && r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		v, ok := p.units[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown unit %q", name)
+		}
+		return v, nil
+	case c == 0:
+		return 0, fmt.Errorf("unexpected end of %q", p.src)
+	default:
+		return 0, fmt.Errorf("unexpected %q in %q", c, p.src)
+	}
+}
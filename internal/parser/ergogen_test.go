@@ -0,0 +1,31 @@
+package parser
+
+import "testing"
+
+// TestErgogenDefaultNames checks that points of zones without rows drop their
+// "_default" suffix, so anchors can refer to them the way Ergogen names them
+func TestErgogenDefaultNames(t *testing.T) {
+	config := `
+points:
+  zones:
+    matrix:
+      columns:
+        a:
+        b:
+    thumb:
+      anchor:
+        ref: matrix_a
+        shift: [0, -19]
+`
+	layout, err := ParseErgogenPoints([]byte(config), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layout.Keys) != 3 {
+		t.Fatalf("got %d keys, want 3", len(layout.Keys))
+	}
+	a, thumb := layout.Keys[0], layout.Keys[2]
+	if thumb.X != a.X || thumb.Y != a.Y+1 {
+		t.Errorf("thumb key at %g,%g, want one unit below matrix_a at %g,%g", thumb.X, thumb.Y, a.X, a.Y)
+	}
+}
@@ -0,0 +1,200 @@
+package parser
+
+import (
+	"math"
+	"sort"
+)
+
+// Point is a position in key units
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+}
+
+// Rect is an axis-aligned rectangle in key units
+type Rect struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	W float64 `json:"w"`
+	H float64 `json:"h"`
+}
+
+// KeyGeometry is the absolute geometry of a key after rotation
+type KeyGeometry struct {
+	Index   int     `json:"index"`
+	Polygon []Point `json:"polygon"` // Outline, clockwise from the top-left corner; ISO enters and stepped caps include their second rectangle
+	Center  Point   `json:"center"`  // Center of the primary rectangle, where the main legend goes
+	Bounds  Rect    `json:"bounds"`
+}
+
+// LayoutGeometry is the absolute geometry of every key of a layout
+type LayoutGeometry struct {
+	Keys   []KeyGeometry `json:"keys"`
+	Extent Rect          `json:"extent"` // Bounding box of all keys
+}
+
+// Rotate turns a point around the key's rotation center
+func (k PhysicalKey) Rotate(x, y float64) Point {
+	if k.R == 0 {
+		return Point{x, y}
+	}
+	rad := k.R * math.Pi / 180
+	dx, dy := x-k.RX, y-k.RY
+	return Point{
+		X: k.RX + dx*math.Cos(rad) - dy*math.Sin(rad),
+		Y: k.RY + dx*math.Sin(rad) + dy*math.Cos(rad),
+	}
+}
+
+// Center returns the center of the key's primary rectangle after rotation
+func (k PhysicalKey) Center() Point {
+	return k.Rotate(k.X+k.W/2, k.Y+k.H/2)
+}
+
+// Outline returns the key's outline before rotation, clockwise from the
+// top-left corner. Keys with a secondary rectangle get the outline of both.
+func (k PhysicalKey) Outline() []Point {
+	outline := []Point{{k.X, k.Y}, {k.X + k.W, k.Y}, {k.X + k.W, k.Y + k.H}, {k.X, k.Y + k.H}}
+	if k.W2 > 0 && k.H2 > 0 {
+		secondary := Rect{k.X + k.X2, k.Y + k.Y2, k.W2, k.H2}
+		if union := rectUnionOutline(Rect{k.X, k.Y, k.W, k.H}, secondary); union != nil {
+			outline = union
+		}
+	}
+	return outline
+}
+
+// Polygon returns the key's outline after rotation, clockwise from the
+// top-left corner
+func (k PhysicalKey) Polygon() []Point {
+	outline := k.Outline()
+	polygon := make([]Point, len(outline))
+	for i, p := range outline {
+		polygon[i] = k.Rotate(p.X, p.Y)
+	}
+	return polygon
+}
+
+// Bounds returns the axis-aligned bounding box of the rotated key
+func (k PhysicalKey) Bounds() Rect {
+	return boundsOf(k.Polygon())
+}
+
+// boundsOf returns the bounding box of a set of points
+func boundsOf(points []Point) Rect {
+	if len(points) == 0 {
+		return Rect{}
+	}
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points[1:] {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	return Rect{minX, minY, maxX - minX, maxY - minY}
+}
+
+// ComputeGeometry returns the rotated outline, center and bounds of every key
+// of a layout, and the extent of the whole layout
+func ComputeGeometry(layout *Layout) LayoutGeometry {
+	geometry := LayoutGeometry{Keys: make([]KeyGeometry, len(layout.Keys))}
+	var corners []Point
+	for i, k := range layout.Keys {
+		polygon := k.Polygon()
+		geometry.Keys[i] = KeyGeometry{
+			Index:   k.Index,
+			Polygon: polygon,
+			Center:  k.Center(),
+			Bounds:  boundsOf(polygon),
+		}
+		corners = append(corners, polygon...)
+	}
+	geometry.Extent = boundsOf(corners)
+	return geometry
+}
+
+// rectUnionOutline traces the outline of two overlapping or touching
+// rectangles, clockwise from the top-left. It returns nil when the rectangles
+// are disjoint and the union has no single outline.
+func rectUnionOutline(a, b Rect) []Point {
+	xs := uniqueSorted(a.X, a.X+a.W, b.X, b.X+b.W)
+	ys := uniqueSorted(a.Y, a.Y+a.H, b.Y, b.Y+b.H)
+	inside := func(r Rect, x, y float64) bool {
+		return x > r.X && x < r.X+r.W && y > r.Y && y < r.Y+r.H
+	}
+	covered := func(i, j int) bool {
+		if i < 0 || j < 0 || i >= len(xs)-1 || j >= len(ys)-1 {
+			return false
+		}
+		x, y := (xs[i]+xs[i+1])/2, (ys[j]+ys[j+1])/2
+		return inside(a, x, y) || inside(b, x, y)
+	}
+
+	// Boundary edges of the covered grid cells, directed clockwise
+	next := make(map[Point]Point)
+	count := 0
+	for i := 0; i < len(xs)-1; i++ {
+		for j := 0; j < len(ys)-1; j++ {
+			if !covered(i, j) {
+				continue
+			}
+			x0, x1, y0, y1 := xs[i], xs[i+1], ys[j], ys[j+1]
+			edges := []struct {
+				from, to Point
+				open     bool
+			}{
+				{Point{x0, y0}, Point{x1, y0}, !covered(i, j-1)},
+				{Point{x1, y0}, Point{x1, y1}, !covered(i+1, j)},
+				{Point{x1, y1}, Point{x0, y1}, !covered(i, j+1)},
+				{Point{x0, y1}, Point{x0, y0}, !covered(i-1, j)},
+			}
+			for _, e := range edges {
+				if e.open {
+					next[e.from] = e.to
+					count++
+				}
+			}
+		}
+	}
+
+	// Start at the top-left and follow the edges around
+	start := Point{math.Inf(1), math.Inf(1)}
+	for p := range next {
+		if p.Y < start.Y || (p.Y == start.Y && p.X < start.X) {
+			start = p
+		}
+	}
+	var loop []Point
+	for p, steps := start, 0; steps == 0 || p != start; steps++ {
+		if steps > count {
+			return nil
+		}
+		loop = append(loop, p)
+		p = next[p]
+	}
+	if len(loop) != count {
+		return nil
+	}
+
+	// Drop points in the middle of straight edges
+	var outline []Point
+	for i, p := range loop {
+		prev, nxt := loop[(i+len(loop)-1)%len(loop)], loop[(i+1)%len(loop)]
+		if (prev.X == p.X && p.X == nxt.X) || (prev.Y == p.Y && p.Y == nxt.Y) {
+			continue
+		}
+		outline = append(outline, p)
+	}
+	return outline
+}
+
+// uniqueSorted returns the distinct values in ascending order
+func uniqueSorted(values ...float64) []float64 {
+	sort.Float64s(values)
+	unique := values[:1]
+	for _, v := range values[1:] {
+		if v != unique[len(unique)-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,186 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// glove80Binding is a binding object from the Glove80 Layout Editor: a value
+// with nested parameters, e.g. {"value": "&kp", "params": [{"value": "LS", "params": [{"value": "A"}]}]}
+type glove80Binding struct {
+	Value  interface{}      `json:"value"`
+	Params []glove80Binding `json:"params"`
+}
+
+// glove80Export is the JSON document exported by the Glove80 Layout Editor
+type glove80Export struct {
+	Title      string             `json:"title"`
+	LayerNames []string           `json:"layer_names"`
+	Layers     [][]glove80Binding `json:"layers"`
+	Macros     []struct {
+		Name     string           `json:"name"`
+		Bindings []glove80Binding `json:"bindings"`
+	} `json:"macros"`
+	HoldTaps []struct {
+		Name     string   `json:"name"`
+		Bindings []string `json:"bindings"`
+	} `json:"holdTaps"`
+	Combos []struct {
+		Name         string         `json:"name"`
+		KeyPositions []int          `json:"keyPositions"`
+		Binding      glove80Binding `json:"binding"`
+		Layers       []int          `json:"layers"`
+	} `json:"combos"`
+}
+
+// layerBehaviors take a layer index as their first parameter
+var layerBehaviors = map[string]bool{
+	"&mo":  true,
+	"&lt":  true,
+	"&to":  true,
+	"&tog": true,
+	"&sl":  true,
+}
+
+// ParseGlove80 parses a MoErgo Glove80 Layout Editor JSON export into a Keymap
+func ParseGlove80(data []byte, name string) (*Keymap, error) {
+	var export glove80Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	if len(export.Layers) == 0 {
+		return nil, fmt.Errorf("Glove80 export has no layers")
+	}
+	if name == "" {
+		name = export.Title
+	}
+
+	keymap := &Keymap{
+		Name:   name,
+		Layers: []Layer{},
+		Format: FormatGlove80,
+	}
+
+	// Hold-taps defined in the editor: first parameter is held, second is tapped
+	holdTaps := map[string]bool{"&mt": true}
+	for _, ht := range export.HoldTaps {
+		holdTaps[ht.Name] = true
+	}
+
+	layerName := func(i int) string {
+		if i >= 0 && i < len(export.LayerNames) {
+			return export.LayerNames[i]
+		}
+		return "Layer " + strconv.Itoa(i)
+	}
+
+	for i, keys := range export.Layers {
+		layer := Layer{
+			Name:        layerName(i),
+			Keys:        make([]string, len(keys)),
+			CustomNames: make(map[string]string),
+			Bindings:    make([]Binding, len(keys)),
+		}
+		for j, raw := range keys {
+			binding := raw.binding()
+			layer.Bindings[j] = binding
+			label, hold := glove80Label(binding, holdTaps, export.LayerNames)
+			layer.Keys[j] = label
+			if hold != "" {
+				if layer.Legends == nil {
+					layer.Legends = make(map[string]KeyLegends)
+				}
+				layer.Legends[strconv.Itoa(j)] = KeyLegends{Hold: hold}
+			}
+		}
+		keymap.Layers = append(keymap.Layers, layer)
+	}
+
+	for _, m := range export.Macros {
+		macro := Macro{Name: m.Name, Bindings: []string{}}
+		for _, b := range m.Bindings {
+			macro.Bindings = append(macro.Bindings, b.binding().String())
+		}
+		keymap.Macros = append(keymap.Macros, macro)
+	}
+
+	for _, c := range export.Combos {
+		label, hold := glove80Label(c.Binding.binding(), holdTaps, export.LayerNames)
+		combo := Combo{
+			Positions: c.KeyPositions,
+			Label:     label,
+			Hold:      hold,
+		}
+		// A layer of -1 means the combo is active everywhere
+		for _, l := range c.Layers {
+			if l < 0 {
+				combo.Layers = nil
+				break
+			}
+			combo.Layers = append(combo.Layers, layerName(l))
+		}
+		keymap.Combos = append(keymap.Combos, combo)
+	}
+
+	return keymap, nil
+}
+
+// binding converts an editor binding object to a Binding
+func (b glove80Binding) binding() Binding {
+	binding := Binding{Behavior: glove80Value(b.Value)}
+	for _, p := range b.Params {
+		binding.Params = append(binding.Params, p.param())
+	}
+	return binding
+}
+
+// param formats a (possibly nested) parameter, e.g. LS(A) or LC(LS(TAB))
+func (b glove80Binding) param() string {
+	value := glove80Value(b.Value)
+	if len(b.Params) == 0 {
+		return value
+	}
+	inner := make([]string, len(b.Params))
+	for i, p := range b.Params {
+		inner[i] = p.param()
+	}
+	return value + "(" + strings.Join(inner, ",") + ")"
+}
+
+// glove80Value formats a binding value, which the editor writes as a string or a number
+func glove80Value(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// glove80Label returns the display label and hold legend for a binding
+func glove80Label(b Binding, holdTaps map[string]bool, layerNames []string) (string, string) {
+	if b.Behavior == "" {
+		return "", ""
+	}
+
+	if holdTaps[b.Behavior] && len(b.Params) >= 2 {
+		return formatKey(b.Params[1]), formatKey(b.Params[0])
+	}
+
+	// Layer behaviors reference layers by index, label them by name
+	if layerBehaviors[b.Behavior] && len(b.Params) >= 1 {
+		if i, err := strconv.Atoi(b.Params[0]); err == nil && i >= 0 && i < len(layerNames) {
+			layer := strings.ReplaceAll(layerNames[i], " ", "_")
+			named := Binding{Behavior: b.Behavior, Params: append([]string{layer}, b.Params[1:]...)}
+			return convertBinding(named.String()), ""
+		}
+	}
+
+	return convertBinding(b.String()), ""
+}
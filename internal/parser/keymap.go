@@ -5,16 +5,77 @@ import (
 	"strings"
 )
 
+// Keymap source formats
+const (
+	FormatZMK          = "zmk"
+	FormatKeymapDrawer = "keymap-drawer"
+	FormatGlove80      = "glove80"
+	FormatOryx         = "oryx"
+)
+
 type Keymap struct {
-	Name   string  `json:"name"`
-	Layers []Layer `json:"layers"`
-	Layout *Layout `json:"layout,omitempty"` // Physical layout for self-contained keymap files
+	Name      string          `json:"name"`
+	Layers    []Layer         `json:"layers"`
+	Combos    []Combo         `json:"combos,omitempty"`
+	Macros    []Macro         `json:"macros,omitempty"`
+	Layout    *Layout         `json:"layout,omitempty"`    // Physical layout for self-contained keymap files
+	LayoutRef *LayoutRef      `json:"layoutRef,omitempty"` // Stored layout the keymap is drawn on
+	Drawer    *DrawerSettings `json:"drawer,omitempty"`    // keymap-drawer sections kept for round-trips
+	Matrix    [][]int         `json:"matrix,omitempty"`    // [row, col] of each binding, from the matrix transform
+	Format    string          `json:"format,omitempty"`    // Source format, e.g. "zmk" or "oryx"; position maps are stored per format
 }
 
 type Layer struct {
-	Name        string            `json:"name"`
-	Keys        []string          `json:"keys"`        // Flat array of key labels, indexed by position
-	CustomNames map[string]string `json:"customNames"` // Custom names: key index (as string) -> custom label
+	Name        string                `json:"name"`
+	Keys        []string              `json:"keys"`               // Flat array of key labels, indexed by position
+	CustomNames map[string]string     `json:"customNames"`        // Custom names: key index (as string) -> custom label
+	Legends     map[string]KeyLegends `json:"legends,omitempty"`  // Extra legends: key index (as string) -> legends
+	Bindings    []Binding             `json:"bindings,omitempty"` // Firmware bindings behind the labels, when known
+	Actions     map[string]KeyActions `json:"actions,omitempty"`  // Tap/hold/double-tap actions: key index (as string) -> actions
+	Colors      map[string]string     `json:"colors,omitempty"`   // Key colors: key index (as string) -> CSS color
+}
+
+// Binding is a firmware behavior bound to a key, with its parameters
+type Binding struct {
+	Behavior string   `json:"behavior"`         // Behavior reference such as "&kp" or "&lt"
+	Params   []string `json:"params,omitempty"` // Parameters, nested ones written as "LS(A)"
+}
+
+// String returns the binding in ZMK keymap syntax
+func (b Binding) String() string {
+	return strings.Join(append([]string{b.Behavior}, b.Params...), " ")
+}
+
+// KeyLegends holds the legends of a key beyond its main (tap) label
+type KeyLegends struct {
+	Hold    string `json:"hold,omitempty"`    // Label shown when the key is held
+	Shifted string `json:"shifted,omitempty"` // Label shown for the shifted key
+	Type    string `json:"type,omitempty"`    // Display hint such as "trans", "held" or "ghost"
+}
+
+// Combo is a binding triggered by pressing several key positions together
+type Combo struct {
+	Positions []int    `json:"positions"`
+	Label     string   `json:"label"`
+	Hold      string   `json:"hold,omitempty"`
+	Layers    []string `json:"layers,omitempty"` // Layer names the combo is active on (all if empty)
+	Hidden    bool     `json:"hidden,omitempty"` // Kept for round-trips but not drawn
+}
+
+// Macro is a named sequence of bindings
+type Macro struct {
+	Name     string   `json:"name"`
+	Bindings []string `json:"bindings"`
+	Sequence []string `json:"sequence,omitempty"` // Keys typed after the leader key to run the macro, for leader sequences
+}
+
+// BindingCount returns the number of bindings of the longest layer
+func (k *Keymap) BindingCount() int {
+	count := 0
+	for _, layer := range k.Layers {
+		count = max(count, len(layer.Keys))
+	}
+	return count
 }
 
 // ParseKeymap parses a ZMK keymap file content and returns a Keymap structure
@@ -22,55 +83,25 @@ func ParseKeymap(content string, name string) (*Keymap, error) {
 	keymap := &Keymap{
 		Name:   name,
 		Layers: []Layer{},
+		Format: FormatZMK,
 	}
 
-	// Find all ZMK_LAYER definitions using balanced parentheses matching
-	prefix := "ZMK_LAYER"
-	idx := 0
-	for {
-		start := strings.Index(content[idx:], prefix)
-		if start == -1 {
-			break
-		}
-		start += idx
-
-		// Find the opening paren
-		parenStart := strings.Index(content[start:], "(")
-		if parenStart == -1 {
-			break
+	// Layers are the ZMK_LAYER macros, or else the layer nodes of the keymap
+	for _, site := range keymapLayerSites(content) {
+		name := site.DisplayName
+		if name == "" {
+			name = formatLayerName(site.Name)
 		}
-		parenStart += start
-
-		// Find matching closing paren using balance counting
-		parenEnd := findMatchingParen(content, parenStart)
-		if parenEnd == -1 {
-			idx = parenStart + 1
-			continue
-		}
-
-		// Extract content between parens
-		innerContent := content[parenStart+1 : parenEnd]
-
-		// Split into layer name and keys (first comma separates them)
-		commaIdx := strings.Index(innerContent, ",")
-		if commaIdx == -1 {
-			idx = parenEnd + 1
-			continue
-		}
-
-		layerName := strings.TrimSpace(innerContent[:commaIdx])
-		keysContent := innerContent[commaIdx+1:]
-
-		layer := Layer{
-			Name:        formatLayerName(layerName),
-			Keys:        parseKeysFlat(keysContent),
+		keymap.Layers = append(keymap.Layers, Layer{
+			Name:        name,
+			Keys:        siteKeys(content, site),
 			CustomNames: make(map[string]string),
-		}
-		keymap.Layers = append(keymap.Layers, layer)
-
-		idx = parenEnd + 1
+		})
 	}
 
+	keymap.Matrix = parseKeymapMatrix(content)
+	parseKeymapBehaviors(content, keymap)
+
 	return keymap, nil
 }
 
@@ -0,0 +1,524 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DrawerSettings keeps the keymap-drawer sections that have no Keymap equivalent
+type DrawerSettings struct {
+	Layout     map[string]interface{} `json:"layout,omitempty"`     // keymap-drawer "layout" spec (qmk_keyboard, ortho_layout, ...)
+	DrawConfig map[string]interface{} `json:"drawConfig,omitempty"` // keymap-drawer "draw_config" section
+}
+
+// drawerDoc is the top level of a keymap-drawer YAML file
+type drawerDoc struct {
+	Layout     map[string]interface{} `yaml:"layout,omitempty"`
+	Layers     yaml.Node              `yaml:"layers"`
+	Combos     []drawerCombo          `yaml:"combos,omitempty"`
+	DrawConfig map[string]interface{} `yaml:"draw_config,omitempty"`
+}
+
+// drawerKey is a keymap-drawer key spec, either a plain legend or a {t, h, s, type} mapping
+type drawerKey struct {
+	T    string `yaml:"t,omitempty"`
+	H    string `yaml:"h,omitempty"`
+	S    string `yaml:"s,omitempty"`
+	Type string `yaml:"type,omitempty"`
+}
+
+// drawerCombo is a keymap-drawer combo spec
+type drawerCombo struct {
+	P      []int     `yaml:"p,omitempty"`
+	K      drawerKey `yaml:"k,omitempty"`
+	L      []string  `yaml:"l,omitempty"`
+	Hidden bool      `yaml:"hidden,omitempty"`
+}
+
+func (k *drawerKey) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag != "!!null" {
+			k.T = node.Value
+		}
+		return nil
+	case yaml.MappingNode:
+		// keymap-drawer accepts both the short and the long field names
+		var spec struct {
+			T       string `yaml:"t"`
+			Tap     string `yaml:"tap"`
+			H       string `yaml:"h"`
+			Hold    string `yaml:"hold"`
+			S       string `yaml:"s"`
+			Shifted string `yaml:"shifted"`
+			Type    string `yaml:"type"`
+		}
+		if err := node.Decode(&spec); err != nil {
+			return err
+		}
+		k.T = firstNonEmpty(spec.T, spec.Tap)
+		k.H = firstNonEmpty(spec.H, spec.Hold)
+		k.S = firstNonEmpty(spec.S, spec.Shifted)
+		k.Type = spec.Type
+		return nil
+	}
+	return fmt.Errorf("line %d: unexpected key spec", node.Line)
+}
+
+func (k drawerKey) MarshalYAML() (interface{}, error) {
+	if k.H == "" && k.S == "" && k.Type == "" {
+		return k.T, nil
+	}
+	type plain drawerKey
+	return plain(k), nil
+}
+
+func (c *drawerCombo) UnmarshalYAML(node *yaml.Node) error {
+	var spec struct {
+		P            []int     `yaml:"p"`
+		KeyPositions []int     `yaml:"key_positions"`
+		K            drawerKey `yaml:"k"`
+		Key          drawerKey `yaml:"key"`
+		L            []string  `yaml:"l"`
+		Layers       []string  `yaml:"layers"`
+		Hidden       bool      `yaml:"hidden"`
+	}
+	if err := node.Decode(&spec); err != nil {
+		return err
+	}
+	c.P = spec.P
+	if len(c.P) == 0 {
+		c.P = spec.KeyPositions
+	}
+	c.K = spec.K
+	if c.K == (drawerKey{}) {
+		c.K = spec.Key
+	}
+	c.L = spec.L
+	if len(c.L) == 0 {
+		c.L = spec.Layers
+	}
+	c.Hidden = spec.Hidden
+	return nil
+}
+
+// ParseKeymapDrawer parses a keymap-drawer YAML file into a Keymap.
+// The returned Layout is nil unless the file describes its geometry inline
+// (ortho_layout or cols_thumbs_notation); references to external boards are
+// kept in Keymap.Drawer so they survive an export.
+func ParseKeymapDrawer(data []byte, name string) (*Keymap, *Layout, error) {
+	var doc drawerDoc
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Layers.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("keymap-drawer file has no layers mapping")
+	}
+
+	keymap := &Keymap{
+		Name:   name,
+		Layers: []Layer{},
+		Format: FormatKeymapDrawer,
+	}
+
+	// Layers are a mapping, walk the node to keep their order
+	for i := 0; i+1 < len(doc.Layers.Content); i += 2 {
+		layerName := doc.Layers.Content[i].Value
+		var specs []drawerKey
+		if err := flattenDrawerKeys(doc.Layers.Content[i+1], &specs); err != nil {
+			return nil, nil, fmt.Errorf("layer %q: %w", layerName, err)
+		}
+
+		layer := Layer{
+			Name:        layerName,
+			Keys:        make([]string, len(specs)),
+			CustomNames: make(map[string]string),
+		}
+		for j, spec := range specs {
+			label := spec.T
+			if label == "" && spec.Type == "trans" {
+				label = "▽"
+			}
+			layer.Keys[j] = label
+			if spec.H != "" || spec.S != "" || spec.Type != "" {
+				if layer.Legends == nil {
+					layer.Legends = make(map[string]KeyLegends)
+				}
+				layer.Legends[strconv.Itoa(j)] = KeyLegends{Hold: spec.H, Shifted: spec.S, Type: spec.Type}
+			}
+		}
+		keymap.Layers = append(keymap.Layers, layer)
+	}
+
+	for _, c := range doc.Combos {
+		keymap.Combos = append(keymap.Combos, Combo{
+			Positions: c.P,
+			Label:     c.K.T,
+			Hold:      c.K.H,
+			Layers:    c.L,
+			Hidden:    c.Hidden,
+		})
+	}
+
+	if doc.Layout != nil || doc.DrawConfig != nil {
+		keymap.Drawer = &DrawerSettings{Layout: doc.Layout, DrawConfig: doc.DrawConfig}
+	}
+
+	layout, err := drawerPhysicalLayout(doc.Layout, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return keymap, layout, nil
+}
+
+// flattenDrawerKeys collects key specs from a layer, which may be a flat list or a list of rows
+func flattenDrawerKeys(node *yaml.Node, specs *[]drawerKey) error {
+	if node.Kind != yaml.SequenceNode {
+		return fmt.Errorf("line %d: expected a list of keys", node.Line)
+	}
+	for _, item := range node.Content {
+		if item.Kind == yaml.SequenceNode {
+			if err := flattenDrawerKeys(item, specs); err != nil {
+				return err
+			}
+			continue
+		}
+		var spec drawerKey
+		if err := item.Decode(&spec); err != nil {
+			return err
+		}
+		*specs = append(*specs, spec)
+	}
+	return nil
+}
+
+// ExportKeymapDrawer serializes a Keymap to keymap-drawer YAML. When a layout
+// is given, each layer is split into rows following the layout's key rows.
+func ExportKeymapDrawer(keymap *Keymap, layout *Layout) ([]byte, error) {
+	doc := struct {
+		Layout     map[string]interface{} `yaml:"layout,omitempty"`
+		Layers     *yaml.Node             `yaml:"layers"`
+		Combos     []drawerCombo          `yaml:"combos,omitempty"`
+		DrawConfig map[string]interface{} `yaml:"draw_config,omitempty"`
+	}{
+		Layers: &yaml.Node{Kind: yaml.MappingNode},
+	}
+	if keymap.Drawer != nil {
+		doc.Layout = keymap.Drawer.Layout
+		doc.DrawConfig = keymap.Drawer.DrawConfig
+	}
+
+	for _, layer := range keymap.Layers {
+		rowsNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, row := range drawerRows(len(layer.Keys), layout) {
+			rowNode := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+			for _, idx := range row {
+				keyNode := &yaml.Node{}
+				if err := keyNode.Encode(layerDrawerKey(layer, idx)); err != nil {
+					return nil, err
+				}
+				rowNode.Content = append(rowNode.Content, keyNode)
+			}
+			rowsNode.Content = append(rowsNode.Content, rowNode)
+		}
+		doc.Layers.Content = append(doc.Layers.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: layer.Name},
+			rowsNode,
+		)
+	}
+
+	for _, c := range keymap.Combos {
+		doc.Combos = append(doc.Combos, drawerCombo{
+			P:      c.Positions,
+			K:      drawerKey{T: c.Label, H: c.Hold},
+			L:      c.Layers,
+			Hidden: c.Hidden,
+		})
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// layerDrawerKey builds the keymap-drawer key spec for one key of a layer
+func layerDrawerKey(layer Layer, idx int) drawerKey {
+	key := strconv.Itoa(idx)
+	spec := drawerKey{T: layer.Keys[idx]}
+	if custom, ok := layer.CustomNames[key]; ok {
+		spec.T = custom
+	}
+	if legends, ok := layer.Legends[key]; ok {
+		spec.H = legends.Hold
+		spec.S = legends.Shifted
+		spec.Type = legends.Type
+	}
+	if layer.Keys[idx] == "▽" && spec.Type == "" {
+		spec.Type = "trans"
+	}
+	return spec
+}
+
+// drawerRows groups key indices into rows, following the layout rows when one is
+// given: a new row starts when the key a binding lands on changes row or rotation
+func drawerRows(count int, layout *Layout) [][]int {
+	var rows [][]int
+	next := 0
+	if layout != nil {
+		keys := make(map[int]PhysicalKey)
+		for _, key := range layout.Keys {
+			if key.Index >= 0 {
+				keys[key.Index] = key
+			}
+		}
+
+		var row []int
+		var prev PhysicalKey
+		for ; next < count; next++ {
+			key, ok := keys[next]
+			if !ok {
+				break
+			}
+			if len(row) > 0 && (key.Y != prev.Y || key.R != prev.R) {
+				rows = append(rows, row)
+				row = nil
+			}
+			row = append(row, next)
+			prev = key
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	// Keys the layout doesn't cover go on a final row
+	if next < count {
+		var rest []int
+		for ; next < count; next++ {
+			rest = append(rest, next)
+		}
+		rows = append(rows, rest)
+	}
+	return rows
+}
+
+// drawerPhysicalLayout builds a Layout from the inline keymap-drawer layout specs
+func drawerPhysicalLayout(spec map[string]interface{}, name string) (*Layout, error) {
+	if spec == nil {
+		return nil, nil
+	}
+	if ortho, ok := spec["ortho_layout"].(map[string]interface{}); ok {
+		return orthoLayout(ortho, name)
+	}
+	if notation, ok := spec["cols_thumbs_notation"].(string); ok {
+		return colsThumbsLayout(notation, name)
+	}
+	return nil, nil
+}
+
+// orthoLayout generates the grid described by a keymap-drawer ortho_layout spec
+func orthoLayout(spec map[string]interface{}, name string) (*Layout, error) {
+	split, _ := spec["split"].(bool)
+	rows, _ := spec["rows"].(int)
+	cols, _ := spec["columns"].(int)
+	dropPinky, _ := spec["drop_pinky"].(bool)
+	dropInner, _ := spec["drop_inner"].(bool)
+	if rows <= 0 || cols <= 0 {
+		return nil, fmt.Errorf("ortho_layout needs positive rows and columns")
+	}
+
+	layout := &Layout{Name: name, Keys: []PhysicalKey{}}
+	add := func(x, y, w float64) {
+		layout.Keys = append(layout.Keys, PhysicalKey{X: x, Y: y, W: w, H: 1, Index: len(layout.Keys)})
+	}
+
+	if split {
+		thumbs, _ := spec["thumbs"].(int)
+		rightX := float64(cols) + 1
+		for r := 0; r < rows; r++ {
+			last := r == rows-1
+			for c := 0; c < cols; c++ {
+				if last && ((dropPinky && c == 0) || (dropInner && c == cols-1)) {
+					continue
+				}
+				add(float64(c), float64(r), 1)
+			}
+			for c := 0; c < cols; c++ {
+				if last && ((dropInner && c == 0) || (dropPinky && c == cols-1)) {
+					continue
+				}
+				add(rightX+float64(c), float64(r), 1)
+			}
+		}
+		for t := 0; t < thumbs; t++ {
+			add(float64(cols-thumbs+t), float64(rows), 1)
+		}
+		for t := 0; t < thumbs; t++ {
+			add(rightX+float64(t), float64(rows), 1)
+		}
+		return layout, nil
+	}
+
+	gridRows := rows
+	thumbs := spec["thumbs"]
+	if thumbs == "MIT" || thumbs == "2x2u" {
+		// The bottom row is replaced with 2u spacebars
+		gridRows = rows - 1
+	}
+	for r := 0; r < gridRows; r++ {
+		for c := 0; c < cols; c++ {
+			add(float64(c), float64(r), 1)
+		}
+	}
+	switch thumbs {
+	case "MIT":
+		mid := (cols - 2) / 2
+		for c := 0; c < cols; {
+			if c == mid {
+				add(float64(c), float64(gridRows), 2)
+				c += 2
+				continue
+			}
+			add(float64(c), float64(gridRows), 1)
+			c++
+		}
+	case "2x2u":
+		mid := (cols - 4) / 2
+		for c := 0; c < cols; {
+			if c == mid || c == mid+2 {
+				add(float64(c), float64(gridRows), 2)
+				c += 2
+				continue
+			}
+			add(float64(c), float64(gridRows), 1)
+			c++
+		}
+	default:
+		if n, ok := thumbs.(int); ok {
+			start := float64(cols-n) / 2
+			for t := 0; t < n; t++ {
+				add(start+float64(t), float64(rows), 1)
+			}
+		}
+	}
+	return layout, nil
+}
+
+// colsThumbsLayout generates the layout described by a cols_thumbs_notation string
+// such as "33333+2 2+33333": digits are column heights, digits on the other side
+// of '+' are thumb keys placed under the inner columns. Each '^' or 'v' after a
+// column moves it up or down half a key, each '<' or '>' after a thumb count
+// moves the thumb keys left or right half a key.
+func colsThumbsLayout(notation string, name string) (*Layout, error) {
+	type half struct {
+		cols        []int
+		shifts      []float64 // Vertical offset of each column
+		thumbs      int
+		thumbsShift float64
+		thumbsRight bool // Thumb keys are aligned with the right edge of the half
+		offset      float64
+	}
+
+	var halves []half
+	maxRows := 0
+	bottom := 0.0 // Bottom edge of the lowest column, where the thumb keys go
+	offset := 0.0
+	parts := strings.Fields(notation)
+	for i, part := range parts {
+		plus := strings.Index(part, "+")
+		colsPart, thumbsPart := part, ""
+		thumbsRight := true
+		if plus != -1 {
+			colsPart, thumbsPart = part[:plus], part[plus+1:]
+			// The right half of a split board lists its thumbs first ("2+33333")
+			if i > 0 && i == len(parts)-1 {
+				colsPart, thumbsPart = thumbsPart, colsPart
+				thumbsRight = false
+			}
+		}
+
+		h := half{thumbsRight: thumbsRight, offset: offset}
+		for _, ch := range colsPart {
+			switch {
+			case ch >= '0' && ch <= '9':
+				h.cols = append(h.cols, int(ch-'0'))
+				h.shifts = append(h.shifts, 0)
+				if int(ch-'0') > maxRows {
+					maxRows = int(ch - '0')
+				}
+			case (ch == '^' || ch == 'v') && len(h.cols) > 0:
+				if ch == '^' {
+					h.shifts[len(h.shifts)-1] -= 0.5
+				} else {
+					h.shifts[len(h.shifts)-1] += 0.5
+				}
+			default:
+				return nil, fmt.Errorf("invalid column height %q in %q", ch, part)
+			}
+		}
+		for c, height := range h.cols {
+			bottom = math.Max(bottom, float64(height)+h.shifts[c])
+		}
+		if thumbsPart != "" {
+			count := strings.Trim(thumbsPart, "<>")
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				return nil, fmt.Errorf("invalid thumb count in %q", part)
+			}
+			h.thumbs = n
+			h.thumbsShift = 0.5 * float64(strings.Count(thumbsPart, ">")-strings.Count(thumbsPart, "<"))
+		}
+		halves = append(halves, h)
+		offset += float64(len(h.cols)) + 1
+	}
+	if len(halves) == 0 {
+		return nil, fmt.Errorf("empty cols_thumbs_notation")
+	}
+
+	layout := &Layout{Name: name, Keys: []PhysicalKey{}}
+	add := func(x, y float64) {
+		layout.Keys = append(layout.Keys, PhysicalKey{X: x, Y: y, W: 1, H: 1, Index: len(layout.Keys)})
+	}
+
+	for r := 0; r < maxRows; r++ {
+		for _, h := range halves {
+			for c, height := range h.cols {
+				if r < height {
+					add(h.offset+float64(c), float64(r)+h.shifts[c])
+				}
+			}
+		}
+	}
+	for _, h := range halves {
+		start := h.offset + h.thumbsShift
+		if h.thumbsRight {
+			start += float64(len(h.cols) - h.thumbs)
+		}
+		for t := 0; t < h.thumbs; t++ {
+			add(start+float64(t), bottom)
+		}
+	}
+	return layout, nil
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
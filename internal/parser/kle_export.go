@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Legend slots keymap labels are exported to
+const (
+	kleTapSlot     = 4 // Center
+	kleShiftedSlot = 1 // Top center
+	kleHoldSlot    = 7 // Bottom center
+)
+
+// kleExportAlign is the alignment flag used on export. With no centering,
+// every one of the 12 legend slots has its own position in the raw string.
+const kleExportAlign = 0
+
+// kleProps is a KLE property object that keeps the order properties were set in
+type kleProps struct {
+	keys   []string
+	values []interface{}
+}
+
+func (p *kleProps) set(key string, value interface{}) {
+	p.keys = append(p.keys, key)
+	p.values = append(p.values, value)
+}
+
+func (p kleProps) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range p.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(p.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// kleRawOrder turns 12 legend slots into the raw KLE legend order for the export alignment
+func kleRawOrder(slots []string) []string {
+	raw := make([]string, 12)
+	for i, slot := range kleLabelMap[kleExportAlign] {
+		if slot < len(slots) {
+			raw[i] = slots[slot]
+		}
+	}
+	return raw
+}
+
+// kleJoin joins raw legends or colors the way KLE does, without trailing newlines
+func kleJoin(values []string) string {
+	return strings.TrimRight(strings.Join(values, "\n"), "\n")
+}
+
+// kleRound trims floating point noise from accumulated positions
+func kleRound(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// LayerLegends returns the legend slots for binding idx of a layer: the tap
+// label (or custom name) in the center, the hold legend at the bottom and the
+// shifted legend at the top
+func LayerLegends(layer Layer, idx int) []string {
+	slots := make([]string, 12)
+	if idx < 0 || idx >= len(layer.Keys) {
+		return slots
+	}
+	key := strconv.Itoa(idx)
+	slots[kleTapSlot] = layer.Keys[idx]
+	if custom, ok := layer.CustomNames[key]; ok {
+		slots[kleTapSlot] = custom
+	}
+	if legends, ok := layer.Legends[key]; ok {
+		slots[kleHoldSlot] = legends.Hold
+		slots[kleShiftedSlot] = legends.Shifted
+	}
+	return slots
+}
+
+// ExportKLE serializes a layout to KLE raw data, following keyboard-layout-editor's
+// serializer. Keys keep their order so indices survive a round trip. When a
+// layer is given, its labels replace the legends of the layout; keys are
+// matched to bindings by their index, so pass a layout from BindLayout to
+// follow a position map.
+func ExportKLE(layout *Layout, layer *Layer) ([]byte, error) {
+	rows := []interface{}{}
+	if layout.Meta != nil && *layout.Meta != (LayoutMeta{}) {
+		rows = append(rows, layout.Meta)
+	}
+
+	// Serializer state, starting from KLE's defaults
+	x, y := 0.0, -1.0
+	r, rx, ry := 0.0, 0.0, 0.0
+	color, textColor := "#cccccc", "#000000"
+	align := 4
+	ghost := false
+	profile := ""
+
+	var row []interface{}
+	newRow := true
+	for _, key := range layout.Keys {
+		// A new row starts when the key moves down or into another rotation cluster
+		if row != nil && (key.Y != y || key.R != r || key.RX != rx || key.RY != ry) {
+			rows = append(rows, row)
+			row = nil
+			newRow = true
+		}
+		if newRow {
+			y++
+			// y resets to the rotation origin whenever the origin changes
+			if key.RX != rx || key.RY != ry {
+				y = key.RY
+			}
+			x = key.RX
+			newRow = false
+		}
+		if row == nil {
+			row = []interface{}{}
+		}
+
+		slots := key.Labels
+		if layer != nil && key.Index >= 0 {
+			slots = LayerLegends(*layer, key.Index)
+		}
+		labels := kleRawOrder(slots)
+
+		// Rotation only changes at the start of a row, where the row break put it
+		var props kleProps
+		if key.R != r {
+			props.set("r", key.R)
+			r = key.R
+		}
+		if key.RX != rx {
+			props.set("rx", key.RX)
+			rx = key.RX
+		}
+		if key.RY != ry {
+			props.set("ry", key.RY)
+			ry = key.RY
+		}
+		if dy := kleRound(key.Y - y); dy != 0 {
+			props.set("y", dy)
+		}
+		y = key.Y
+		if dx := kleRound(key.X - x); dx != 0 {
+			props.set("x", dx)
+		}
+		x = key.X + key.W
+
+		keyColor := key.Color
+		if keyColor == "" {
+			keyColor = "#cccccc"
+		}
+		if keyColor != color {
+			props.set("c", keyColor)
+			color = keyColor
+		}
+
+		keyTextColor := key.TextColor
+		if keyTextColor == "" {
+			keyTextColor = "#000000"
+		}
+		colors := []string{keyTextColor}
+		if len(key.TextColors) > 0 && layer == nil {
+			colors = kleRawOrder(key.TextColors)
+			if colors[0] == "" {
+				colors[0] = keyTextColor
+			}
+		}
+		if t := kleJoin(colors); t != textColor {
+			props.set("t", t)
+			textColor = t
+		}
+
+		if key.Ghost != ghost {
+			props.set("g", key.Ghost)
+			ghost = key.Ghost
+		}
+		if key.Profile != profile {
+			props.set("p", key.Profile)
+			profile = key.Profile
+		}
+		if align != kleExportAlign {
+			props.set("a", kleExportAlign)
+			align = kleExportAlign
+		}
+
+		if key.W != 1 {
+			props.set("w", key.W)
+		}
+		if key.H != 1 {
+			props.set("h", key.H)
+		}
+		if key.W2 > 0 && key.H2 > 0 {
+			if key.W2 != key.W {
+				props.set("w2", key.W2)
+			}
+			if key.H2 != key.H {
+				props.set("h2", key.H2)
+			}
+			if key.X2 != 0 {
+				props.set("x2", key.X2)
+			}
+			if key.Y2 != 0 {
+				props.set("y2", key.Y2)
+			}
+		}
+		if key.Homing {
+			props.set("n", true)
+		}
+		if key.Stepped {
+			props.set("l", true)
+		}
+		if key.Decal {
+			props.set("d", true)
+		}
+
+		if len(props.keys) > 0 {
+			row = append(row, props)
+		}
+		row = append(row, kleJoin(labels))
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	// One row per line, like KLE's raw data view
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i, row := range rows {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("  ")
+		buf.Write(data)
+		if i < len(rows)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("]\n")
+	return buf.Bytes(), nil
+}
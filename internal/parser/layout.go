@@ -1,25 +1,140 @@
 package parser
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"strings"
 )
 
 // PhysicalKey represents a single key's physical position and size
 type PhysicalKey struct {
-	X      float64 `json:"x"`      // X position in key units
-	Y      float64 `json:"y"`      // Y position in key units
-	W      float64 `json:"w"`      // Width in key units (default 1)
-	H      float64 `json:"h"`      // Height in key units (default 1)
-	R      float64 `json:"r"`      // Rotation angle in degrees
-	RX     float64 `json:"rx"`     // Rotation center X
-	RY     float64 `json:"ry"`     // Rotation center Y
-	Index  int     `json:"index"`  // Sequential index for mapping to keymap
+	X       float64 `json:"x"`                 // X position in key units
+	Y       float64 `json:"y"`                 // Y position in key units
+	W       float64 `json:"w"`                 // Width in key units (default 1)
+	H       float64 `json:"h"`                 // Height in key units (default 1)
+	X2      float64 `json:"x2,omitempty"`      // Secondary rectangle X offset (ISO enter, stepped caps)
+	Y2      float64 `json:"y2,omitempty"`      // Secondary rectangle Y offset
+	W2      float64 `json:"w2,omitempty"`      // Secondary rectangle width (0 when there is none)
+	H2      float64 `json:"h2,omitempty"`      // Secondary rectangle height
+	R       float64 `json:"r"`                 // Rotation angle in degrees
+	RX      float64 `json:"rx"`                // Rotation center X
+	RY      float64 `json:"ry"`                // Rotation center Y
+	Index   int     `json:"index"`             // Sequential index for mapping to keymap (-1 for decals and ghosts)
+	Stepped bool    `json:"stepped,omitempty"` // Stepped keycap (e.g. stepped caps lock)
+	Decal   bool    `json:"decal,omitempty"`   // Decoration only, not a real key
+	Ghost   bool    `json:"ghost,omitempty"`   // Drawn faded, not part of this build
+
+	Labels     []string `json:"labels,omitempty"`     // 12 legend slots: top, middle, bottom rows (left/center/right), then front left/center/right
+	TextColors []string `json:"textColors,omitempty"` // Per-legend text colors, same slots as Labels (empty means TextColor)
+	Color      string   `json:"color,omitempty"`      // Keycap color
+	TextColor  string   `json:"textColor,omitempty"`  // Default legend color
+	Profile    string   `json:"profile,omitempty"`    // Keycap profile, e.g. "DCS R1"
+	Homing     bool     `json:"homing,omitempty"`     // Homing nub or bar
+	Matrix     []int    `json:"matrix,omitempty"`     // Electrical matrix position [row, col], when known
+	Option     []int    `json:"option,omitempty"`     // Layout option [group, choice] the key belongs to, see Layout.Variants
 }
 
 // Layout represents a physical keyboard layout
 type Layout struct {
 	Name string        `json:"name"`
 	Keys []PhysicalKey `json:"keys"`
+	Meta *LayoutMeta   `json:"meta,omitempty"` // KLE keyboard metadata
+
+	// PositionMap explicitly maps binding indices to key indices (-1 for none),
+	// for layouts drawn in a different order than the keymap. It takes
+	// priority over matrix positions.
+	PositionMap []int `json:"positionMap,omitempty"`
+
+	// Variants lists the layout's option groups; keys of unselected choices
+	// are still in Keys until SelectVariant drops them
+	Variants []LayoutVariant `json:"variants,omitempty"`
+}
+
+// LayoutMeta is the keyboard metadata object of a KLE file
+type LayoutMeta struct {
+	Name        string         `json:"name,omitempty"`
+	Author      string         `json:"author,omitempty"`
+	Notes       string         `json:"notes,omitempty"`
+	Backcolor   string         `json:"backcolor,omitempty"`
+	Background  *KLEBackground `json:"background,omitempty"`
+	Radii       string         `json:"radii,omitempty"`
+	SwitchMount string         `json:"switchMount,omitempty"`
+	SwitchBrand string         `json:"switchBrand,omitempty"`
+	SwitchType  string         `json:"switchType,omitempty"`
+}
+
+// KLEBackground is the case background texture selected in KLE
+type KLEBackground struct {
+	Name  string `json:"name,omitempty"`
+	Style string `json:"style,omitempty"`
+}
+
+// legendSlotOrder lists legend slots from most to least prominent: center,
+// top-left, then the remaining slots
+var legendSlotOrder = []int{4, 0, 1, 3, 6, 7, 2, 5, 8, 9, 10, 11}
+
+// PrimaryLabel returns the most prominent legend of the key
+func (k PhysicalKey) PrimaryLabel() string {
+	if len(k.Labels) == 0 {
+		return ""
+	}
+	for _, slot := range legendSlotOrder {
+		if slot < len(k.Labels) && k.Labels[slot] != "" {
+			return k.Labels[slot]
+		}
+	}
+	return ""
+}
+
+// kleKey is the full state of a key as defined by keyboard-layout-editor's deserializer
+type kleKey struct {
+	x, y, width, height     float64
+	x2, y2, width2, height2 float64
+	rotationAngle           float64
+	rotationX, rotationY    float64
+	labels                  [12]string
+	textColor               [12]string
+	textSize                [12]float64
+	defaultTextColor        string
+	defaultTextSize         float64
+	color                   string
+	profile                 string
+	nub, stepped            bool
+	decal, ghost            bool
+	switchMount             string
+	switchBrand             string
+	switchType              string
+}
+
+// kleLabelMap maps a legend's position in the raw KLE string to its slot, for each alignment flag value
+var kleLabelMap = [8][12]int{
+	{0, 6, 2, 8, 9, 11, 3, 5, 1, 4, 7, 10},          // 0 = no centering
+	{1, 7, -1, -1, 9, 11, 4, -1, -1, -1, -1, 10},    // 1 = center x
+	{3, -1, 5, -1, 9, 11, -1, -1, 4, -1, -1, 10},    // 2 = center y
+	{4, -1, -1, -1, 9, 11, -1, -1, -1, -1, -1, 10},  // 3 = center x & y
+	{0, 6, 2, 8, 10, -1, 3, 5, 1, 4, 7, -1},         // 4 = center front (default)
+	{1, 7, -1, -1, 10, -1, 4, -1, -1, -1, -1, -1},   // 5 = center front & x
+	{3, -1, 5, -1, 10, -1, -1, -1, 4, -1, -1, -1},   // 6 = center front & y
+	{4, -1, -1, -1, 10, -1, -1, -1, -1, -1, -1, -1}, // 7 = center front & x & y
+}
+
+// reorderKLELabels moves raw KLE legends into their 12 slots according to the alignment flags
+func reorderKLELabels(raw []string, align int) [12]string {
+	var slots [12]string
+	if align < 0 || align >= len(kleLabelMap) {
+		align = 4
+	}
+	for i, label := range raw {
+		if i >= 12 || label == "" {
+			continue
+		}
+		if slot := kleLabelMap[align][i]; slot >= 0 {
+			slots[slot] = label
+		}
+	}
+	return slots
 }
 
 // ParseKLELayout parses a KLE (keyboard-layout-editor.com) JSON format
@@ -29,90 +144,294 @@ func ParseKLELayout(data []byte, name string) (*Layout, error) {
 		return nil, err
 	}
 
+	keys, meta, err := deserializeKLE(raw)
+	if err != nil {
+		return nil, err
+	}
+
 	layout := &Layout{
 		Name: name,
 		Keys: []PhysicalKey{},
+		Meta: meta,
 	}
 
-	// Current state
-	currentX := 0.0
-	currentY := 0.0
-	currentW := 1.0
-	currentH := 1.0
-	currentR := 0.0
-	currentRX := 0.0
-	currentRY := 0.0
+	// Decals and ghosts are drawn but never receive a binding
 	keyIndex := 0
+	for _, k := range keys {
+		key := PhysicalKey{
+			X:       k.x,
+			Y:       k.y,
+			W:       k.width,
+			H:       k.height,
+			R:       k.rotationAngle,
+			RX:      k.rotationX,
+			RY:      k.rotationY,
+			Index:   -1,
+			Stepped: k.stepped,
+			Decal:   k.decal,
+			Ghost:   k.ghost,
 
-	for _, row := range raw {
-		rowArray, ok := row.([]interface{})
-		if !ok {
-			// Skip metadata object at the start if present
-			continue
+			Color:     k.color,
+			TextColor: k.defaultTextColor,
+			Profile:   k.profile,
+			Homing:    k.nub,
+		}
+		if k.labels != ([12]string{}) {
+			labels := k.labels
+			key.Labels = labels[:]
+		}
+		if k.textColor != ([12]string{}) {
+			textColors := k.textColor
+			key.TextColors = textColors[:]
 		}
+		// Only keep the secondary rectangle when it differs from the primary one
+		if k.x2 != 0 || k.y2 != 0 || k.width2 != k.width || k.height2 != k.height {
+			key.X2 = k.x2
+			key.Y2 = k.y2
+			key.W2 = k.width2
+			key.H2 = k.height2
+		}
+		if !k.decal && !k.ghost {
+			key.Index = keyIndex
+			keyIndex++
+		}
+		layout.Keys = append(layout.Keys, key)
+	}
+
+	applyVIALegends(layout)
+	return layout, nil
+}
 
-		// Reset X at start of each row, increment Y
-		currentX = currentRX // Reset to rotation origin X
-		currentY += 1.0
+// deserializeKLE walks KLE raw data the same way keyboard-layout-editor's own
+// deserializer does (kle-serial), returning every key in file order and the
+// keyboard metadata if present
+func deserializeKLE(rows []interface{}) ([]kleKey, *LayoutMeta, error) {
+	current := kleKey{
+		width:            1,
+		height:           1,
+		width2:           1,
+		height2:          1,
+		defaultTextColor: "#000000",
+		defaultTextSize:  3,
+		color:            "#cccccc",
+	}
+	align := 4
+	var clusterX, clusterY float64
+	var keys []kleKey
+	var meta *LayoutMeta
 
-		for _, item := range rowArray {
-			switch v := item.(type) {
-			case map[string]interface{}:
-				// Modifier object - update current state
-				if x, ok := v["x"].(float64); ok {
-					currentX += x
-				}
-				if y, ok := v["y"].(float64); ok {
-					currentY += y
-				}
-				if w, ok := v["w"].(float64); ok {
-					currentW = w
-				}
-				if h, ok := v["h"].(float64); ok {
-					currentH = h
-				}
-				if r, ok := v["r"].(float64); ok {
-					currentR = r
-				}
-				if rx, ok := v["rx"].(float64); ok {
-					currentRX = rx
-					currentX = rx // Reset X to new rotation origin
-				}
-				if ry, ok := v["ry"].(float64); ok {
-					currentRY = ry
-					currentY = ry // Reset Y to new rotation origin
-				}
+	for r, row := range rows {
+		switch items := row.(type) {
+		case map[string]interface{}:
+			// Keyboard metadata, only valid as the first element
+			if r != 0 {
+				return nil, nil, fmt.Errorf("keyboard metadata must be the first element")
+			}
+			meta = parseKLEMeta(items)
+			continue
 
-			case string:
-				// This is a key
-				key := PhysicalKey{
-					X:     currentX,
-					Y:     currentY - 1, // Adjust since we increment Y at row start
-					W:     currentW,
-					H:     currentH,
-					R:     currentR,
-					RX:    currentRX,
-					RY:    currentRY,
-					Index: keyIndex,
-				}
-				layout.Keys = append(layout.Keys, key)
-				keyIndex++
+		case []interface{}:
+			for k, item := range items {
+				switch v := item.(type) {
+				case string:
+					key := current
+					key.labels = reorderKLELabels(strings.Split(v, "\n"), align)
+					key.textSize = reorderKLETextSizes(current.textSize, align)
+					if current.width2 == 0 {
+						key.width2 = current.width
+					}
+					if current.height2 == 0 {
+						key.height2 = current.height
+					}
 
-				// Move X position for next key
-				currentX += currentW
+					// Drop per-legend settings of empty legends or matching the defaults
+					for i := 0; i < 12; i++ {
+						if key.labels[i] == "" || key.textColor[i] == key.defaultTextColor {
+							key.textColor[i] = ""
+						}
+						if key.labels[i] == "" || key.textSize[i] == key.defaultTextSize {
+							key.textSize[i] = 0
+						}
+					}
+					keys = append(keys, key)
 
-				// Reset width/height to defaults after each key
-				currentW = 1.0
-				currentH = 1.0
+					// Set up for the next key
+					current.x += current.width
+					current.width, current.height = 1, 1
+					current.x2, current.y2, current.width2, current.height2 = 0, 0, 0, 0
+					current.nub, current.stepped, current.decal = false, false, false
+
+				case map[string]interface{}:
+					if k != 0 && (v["r"] != nil || v["rx"] != nil || v["ry"] != nil) {
+						return nil, nil, fmt.Errorf("row %d: rotation can only be specified on the first key in a row", r)
+					}
+					if rot, ok := v["r"].(float64); ok {
+						current.rotationAngle = rot
+					}
+					// A new rotation origin starts a cluster: x and y restart from it
+					if rx, ok := v["rx"].(float64); ok {
+						current.rotationX = rx
+						clusterX = rx
+						current.x, current.y = clusterX, clusterY
+					}
+					if ry, ok := v["ry"].(float64); ok {
+						current.rotationY = ry
+						clusterY = ry
+						current.x, current.y = clusterX, clusterY
+					}
+					if a, ok := v["a"].(float64); ok {
+						align = int(a)
+					}
+					if f, ok := v["f"].(float64); ok && f != 0 {
+						current.defaultTextSize = f
+						current.textSize = [12]float64{}
+					}
+					if f2, ok := v["f2"].(float64); ok && f2 != 0 {
+						for i := 1; i < 12; i++ {
+							current.textSize[i] = f2
+						}
+					}
+					if fa, ok := v["fa"].([]interface{}); ok && len(fa) > 0 {
+						current.textSize = [12]float64{}
+						for i, size := range fa {
+							if s, ok := size.(float64); ok && i < 12 {
+								current.textSize[i] = s
+							}
+						}
+					}
+					if p, ok := v["p"].(string); ok && p != "" {
+						current.profile = p
+					}
+					if c, ok := v["c"].(string); ok && c != "" {
+						current.color = c
+					}
+					if t, ok := v["t"].(string); ok && t != "" {
+						split := strings.Split(t, "\n")
+						if split[0] != "" {
+							current.defaultTextColor = split[0]
+						}
+						current.textColor = reorderKLELabels(split, align)
+					}
+					if x, ok := v["x"].(float64); ok {
+						current.x += x
+					}
+					if y, ok := v["y"].(float64); ok {
+						current.y += y
+					}
+					if w, ok := v["w"].(float64); ok && w != 0 {
+						current.width, current.width2 = w, w
+					}
+					if h, ok := v["h"].(float64); ok && h != 0 {
+						current.height, current.height2 = h, h
+					}
+					if x2, ok := v["x2"].(float64); ok {
+						current.x2 = x2
+					}
+					if y2, ok := v["y2"].(float64); ok {
+						current.y2 = y2
+					}
+					if w2, ok := v["w2"].(float64); ok && w2 != 0 {
+						current.width2 = w2
+					}
+					if h2, ok := v["h2"].(float64); ok && h2 != 0 {
+						current.height2 = h2
+					}
+					if n, ok := v["n"].(bool); ok {
+						current.nub = n
+					}
+					if l, ok := v["l"].(bool); ok {
+						current.stepped = l
+					}
+					if d, ok := v["d"].(bool); ok {
+						current.decal = d
+					}
+					if g, ok := v["g"].(bool); ok {
+						current.ghost = g
+					}
+					if sm, ok := v["sm"].(string); ok && sm != "" {
+						current.switchMount = sm
+					}
+					if sb, ok := v["sb"].(string); ok && sb != "" {
+						current.switchBrand = sb
+					}
+					if st, ok := v["st"].(string); ok && st != "" {
+						current.switchType = st
+					}
+				}
 			}
+
+			// End of the row
+			current.y++
+			current.x = current.rotationX
+
+		default:
+			return nil, nil, fmt.Errorf("unexpected element %d in KLE data", r)
 		}
 	}
 
-	return layout, nil
+	return keys, meta, nil
+}
+
+// parseKLEMeta reads the keyboard metadata object of a KLE file
+func parseKLEMeta(v map[string]interface{}) *LayoutMeta {
+	str := func(key string) string {
+		s, _ := v[key].(string)
+		return s
+	}
+	meta := &LayoutMeta{
+		Name:        str("name"),
+		Author:      str("author"),
+		Notes:       str("notes"),
+		Backcolor:   str("backcolor"),
+		Radii:       str("radii"),
+		SwitchMount: str("switchMount"),
+		SwitchBrand: str("switchBrand"),
+		SwitchType:  str("switchType"),
+	}
+	if bg, ok := v["background"].(map[string]interface{}); ok {
+		meta.Background = &KLEBackground{}
+		meta.Background.Name, _ = bg["name"].(string)
+		meta.Background.Style, _ = bg["style"].(string)
+	}
+	return meta
+}
+
+// reorderKLETextSizes applies the legend alignment to per-legend text sizes
+func reorderKLETextSizes(sizes [12]float64, align int) [12]float64 {
+	var slots [12]float64
+	if align < 0 || align >= len(kleLabelMap) {
+		align = 4
+	}
+	for i, size := range sizes {
+		if size == 0 {
+			continue
+		}
+		if slot := kleLabelMap[align][i]; slot >= 0 {
+			slots[slot] = size
+		}
+	}
+	return slots
 }
 
 // KeymapWithLayout combines a parsed keymap with a physical layout
 type KeymapWithLayout struct {
-	Keymap *Keymap `json:"keymap"`
-	Layout *Layout `json:"layout"`
+	Keymap        *Keymap `json:"keymap"`
+	Layout        *Layout `json:"layout"`
+	LayoutChanged bool    `json:"layoutChanged,omitempty"` // The layout no longer matches the keymap's LayoutRef hash
+}
+
+// LayoutRef points a keymap at a stored layout. The hash tells whether the
+// layout changed since the keymap was associated with it.
+type LayoutRef struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// LayoutHash returns a short content hash of a layout, ignoring its name
+func LayoutHash(layout *Layout) string {
+	unnamed := *layout
+	unnamed.Name = ""
+	data, _ := json.Marshal(&unnamed)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
 }
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"math"
+	"sort"
+)
+
+// Weights of the signals combined into a layout match score
+const (
+	matchCountWeight  = 1.0
+	matchMatrixWeight = 2.0
+	matchArtWeight    = 1.0
+)
+
+const (
+	// matchArtCountSlack is the fraction of the binding count the number of
+	// art cells may be off by for the art to be compared at all
+	matchArtCountSlack = 0.1
+	// matchThreshold is the score a layout needs to be picked automatically
+	matchThreshold = 0.9
+	// matchMargin is how far ahead of the runner-up an automatic pick must be
+	matchMargin = 0.1
+)
+
+// LayoutMatch is how well a stored layout fits a keymap. Each signal is scored
+// from 0 to 1; signals missing on either side are left out of the total.
+type LayoutMatch struct {
+	Layout   string   `json:"layout"`
+	Score    float64  `json:"score"`
+	KeyCount float64  `json:"keyCount"`         // Keys in the layout against bindings in the keymap
+	Matrix   *float64 `json:"matrix,omitempty"` // Matrix positions of the keymap's transform found in the layout
+	Art      *float64 `json:"art,omitempty"`    // Keys per row of the keymap's ASCII art against those of the layout
+}
+
+// ScoreLayout scores a layout against a keymap by key count, matrix positions
+// and, when art is given, the ASCII art drawn in the keymap's comments
+func ScoreLayout(layout *Layout, keymap *Keymap, art []ArtCell) LayoutMatch {
+	match := LayoutMatch{Layout: layout.Name}
+
+	bindings := keymap.BindingCount()
+	keys := 0
+	layoutMatrix := make(map[[2]int]bool)
+	for _, k := range layout.Keys {
+		if k.Index < 0 {
+			continue
+		}
+		keys++
+		if len(k.Matrix) == 2 {
+			layoutMatrix[[2]int{k.Matrix[0], k.Matrix[1]}] = true
+		}
+	}
+	if keys > 0 && bindings > 0 {
+		match.KeyCount = math.Min(float64(keys), float64(bindings)) / math.Max(float64(keys), float64(bindings))
+	}
+	total, weights := match.KeyCount*matchCountWeight, matchCountWeight
+
+	// Matrix positions: shared positions over all positions on either side
+	if len(layoutMatrix) > 0 && len(keymap.Matrix) > 0 {
+		keymapMatrix := make(map[[2]int]bool)
+		for _, rc := range keymap.Matrix {
+			if len(rc) == 2 {
+				keymapMatrix[[2]int{rc[0], rc[1]}] = true
+			}
+		}
+		shared := 0
+		for rc := range keymapMatrix {
+			if layoutMatrix[rc] {
+				shared++
+			}
+		}
+		score := float64(shared) / float64(len(layoutMatrix)+len(keymapMatrix)-shared)
+		match.Matrix = &score
+		total += score * matchMatrixWeight
+		weights += matchMatrixWeight
+	}
+
+	// Art with far more or fewer cells than bindings is some other diagram
+	if len(art) > 0 && keys > 0 && math.Abs(float64(len(art)-bindings)) <= float64(bindings)*matchArtCountSlack {
+		score := rowProfileScore(artRowCounts(art), layoutRowCounts(layout))
+		match.Art = &score
+		total += score * matchArtWeight
+		weights += matchArtWeight
+	}
+
+	match.Score = total / weights
+	return match
+}
+
+// artRowCounts counts the cells on each line of art, top to bottom
+func artRowCounts(art []ArtCell) []int {
+	var counts []int
+	for i, cell := range art {
+		if i == 0 || cell.Y != art[i-1].Y {
+			counts = append(counts, 0)
+		}
+		counts[len(counts)-1]++
+	}
+	return counts
+}
+
+// layoutRowCounts counts the keys in each row of a layout, top to bottom
+func layoutRowCounts(layout *Layout) []int {
+	rows := keyRows(layout)
+	counts := make([]int, len(rows))
+	for i, row := range rows {
+		counts[i] = len(row)
+	}
+	return counts
+}
+
+// rowProfileScore compares two lists of keys per row: the keys the rows have
+// in common, row by row, against the larger of the two totals
+func rowProfileScore(a, b []int) float64 {
+	common, totalA, totalB := 0, 0, 0
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i < len(a) {
+			totalA += a[i]
+		}
+		if i < len(b) {
+			totalB += b[i]
+		}
+		if i < len(a) && i < len(b) {
+			common += min(a[i], b[i])
+		}
+	}
+	if totalA == 0 || totalB == 0 {
+		return 0
+	}
+	return float64(common) / float64(max(totalA, totalB))
+}
+
+// RankLayouts scores every layout against a keymap, best match first
+func RankLayouts(layouts []*Layout, keymap *Keymap, art []ArtCell) []LayoutMatch {
+	matches := make([]LayoutMatch, len(layouts))
+	for i, layout := range layouts {
+		matches[i] = ScoreLayout(layout, keymap, art)
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].Score != matches[b].Score {
+			return matches[a].Score > matches[b].Score
+		}
+		return matches[a].Layout < matches[b].Layout
+	})
+	return matches
+}
+
+// UnambiguousMatch returns the best of ranked matches when it scores high
+// enough and clearly ahead of the runner-up
+func UnambiguousMatch(matches []LayoutMatch) (LayoutMatch, bool) {
+	if len(matches) == 0 || matches[0].Score < matchThreshold {
+		return LayoutMatch{}, false
+	}
+	if len(matches) > 1 && matches[0].Score-matches[1].Score < matchMargin {
+		return LayoutMatch{}, false
+	}
+	return matches[0], true
+}
@@ -0,0 +1,301 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// KeyActions holds the four actions an Oryx key can perform, as QMK keycodes
+type KeyActions struct {
+	Tap       string `json:"tap,omitempty"`
+	Hold      string `json:"hold,omitempty"`
+	DoubleTap string `json:"doubleTap,omitempty"`
+	TapHold   string `json:"tapHold,omitempty"`
+}
+
+// oryxAction is one action of an Oryx key
+type oryxAction struct {
+	Code      string          `json:"code"`
+	Layer     *int            `json:"layer"`
+	Modifiers json.RawMessage `json:"modifiers"`
+}
+
+// oryxKey is a key of an Oryx layer
+type oryxKey struct {
+	Tap         *oryxAction `json:"tap"`
+	Hold        *oryxAction `json:"hold"`
+	DoubleTap   *oryxAction `json:"doubleTap"`
+	TapHold     *oryxAction `json:"tapHold"`
+	CustomLabel string      `json:"customLabel"`
+	GlowColor   string      `json:"glowColor"`
+}
+
+// oryxLayout is the layout object of an Oryx export
+type oryxLayout struct {
+	Title    string `json:"title"`
+	Geometry string `json:"geometry"`
+	Revision struct {
+		Layers []struct {
+			Title    string    `json:"title"`
+			Position int       `json:"position"`
+			Color    string    `json:"color"`
+			Keys     []oryxKey `json:"keys"`
+		} `json:"layers"`
+	} `json:"revision"`
+}
+
+// oryxModifier is an Oryx modifier flag with its QMK modifier function and label prefix
+type oryxModifier struct {
+	flag   string
+	qmk    string
+	prefix string
+}
+
+// oryxModifiers are the Oryx modifiers in wrapping order, outermost first;
+// keycodes and labels both list them in this order
+var oryxModifiers = []oryxModifier{
+	{"leftCtrl", "LCTL", "C-"},
+	{"leftShift", "LSFT", "S-"},
+	{"leftAlt", "LALT", "A-"},
+	{"leftGui", "LGUI", "G-"},
+	{"rightCtrl", "RCTL", "C-"},
+	{"rightShift", "RSFT", "S-"},
+	{"rightAlt", "RALT", "A-"},
+	{"rightGui", "RGUI", "G-"},
+}
+
+// qmkKeycodes maps QMK keycode names to the ZMK names formatKey understands
+var qmkKeycodes = map[string]string{
+	"BSPACE":           "BSPC",
+	"BSPC":             "BSPC",
+	"ENT":              "ENTER",
+	"SPC":              "SPACE",
+	"DELETE":           "DEL",
+	"INS":              "INSERT",
+	"PGUP":             "PG_UP",
+	"PGDOWN":           "PG_DN",
+	"PGDN":             "PG_DN",
+	"LSHIFT":           "LSHIFT",
+	"LSFT":             "LSHIFT",
+	"RSHIFT":           "RSHIFT",
+	"RSFT":             "RSHIFT",
+	"LCTRL":            "LCTRL",
+	"LCTL":             "LCTRL",
+	"RCTRL":            "RCTRL",
+	"RCTL":             "RCTRL",
+	"LALT":             "LALT",
+	"RALT":             "RALT",
+	"LGUI":             "LGUI",
+	"RGUI":             "RGUI",
+	"GRV":              "GRAVE",
+	"LBRACKET":         "LBKT",
+	"LBRC":             "LBKT",
+	"RBRACKET":         "RBKT",
+	"RBRC":             "RBKT",
+	"BSLASH":           "BSLH",
+	"BSLS":             "BSLH",
+	"SCOLON":           "SEMI",
+	"SCLN":             "SEMI",
+	"QUOTE":            "SQT",
+	"QUOT":             "SQT",
+	"COMM":             "COMMA",
+	"SLSH":             "SLASH",
+	"MINS":             "MINUS",
+	"EQL":              "EQUAL",
+	"CAPSLOCK":         "CAPS",
+	"CAPS":             "CAPS",
+	"PSCREEN":          "PSCRN",
+	"PSCR":             "PSCRN",
+	"AUDIO_MUTE":       "C_MUTE",
+	"MUTE":             "C_MUTE",
+	"AUDIO_VOL_UP":     "C_VOL_UP",
+	"VOLU":             "C_VOL_UP",
+	"AUDIO_VOL_DOWN":   "C_VOL_DN",
+	"VOLD":             "C_VOL_DN",
+	"MEDIA_PLAY_PAUSE": "C_PLAY_PAUSE",
+	"MPLY":             "C_PLAY_PAUSE",
+	"MEDIA_NEXT_TRACK": "C_NEXT",
+	"MNXT":             "C_NEXT",
+	"MEDIA_PREV_TRACK": "C_PREV",
+	"MPRV":             "C_PREV",
+}
+
+// ParseOryx parses a ZSA Oryx layout export (the GraphQL "data.layout" document
+// or the bare layout object) into a Keymap. The keyboard geometry, e.g.
+// "moonlander" or "voyager", is returned alongside.
+func ParseOryx(data []byte, name string) (*Keymap, string, error) {
+	var wrapped struct {
+		Data struct {
+			Layout *oryxLayout `json:"layout"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, "", err
+	}
+	layout := wrapped.Data.Layout
+	if layout == nil {
+		layout = &oryxLayout{}
+		if err := json.Unmarshal(data, layout); err != nil {
+			return nil, "", err
+		}
+	}
+	if len(layout.Revision.Layers) == 0 {
+		return nil, "", fmt.Errorf("Oryx export has no layers")
+	}
+	if name == "" {
+		name = layout.Title
+	}
+
+	layers := layout.Revision.Layers
+	sort.SliceStable(layers, func(i, j int) bool { return layers[i].Position < layers[j].Position })
+
+	layerTitle := func(i int) string {
+		if i >= 0 && i < len(layers) && layers[i].Title != "" {
+			return layers[i].Title
+		}
+		return "Layer " + strconv.Itoa(i)
+	}
+
+	keymap := &Keymap{
+		Name:   name,
+		Layers: []Layer{},
+		Format: FormatOryx,
+	}
+
+	for i, l := range layers {
+		layer := Layer{
+			Name:        layerTitle(i),
+			Keys:        make([]string, len(l.Keys)),
+			CustomNames: make(map[string]string),
+			Actions:     make(map[string]KeyActions),
+		}
+		for j, k := range l.Keys {
+			idx := strconv.Itoa(j)
+			actions := KeyActions{
+				Tap:       k.Tap.qmk(),
+				Hold:      k.Hold.qmk(),
+				DoubleTap: k.DoubleTap.qmk(),
+				TapHold:   k.TapHold.qmk(),
+			}
+			if actions != (KeyActions{}) {
+				layer.Actions[idx] = actions
+			}
+
+			layer.Keys[j] = k.Tap.label(layerTitle)
+			if hold := k.Hold.label(layerTitle); hold != "" && hold != "▽" {
+				if layer.Legends == nil {
+					layer.Legends = make(map[string]KeyLegends)
+				}
+				layer.Legends[idx] = KeyLegends{Hold: hold}
+			}
+			if k.CustomLabel != "" {
+				layer.CustomNames[idx] = k.CustomLabel
+			}
+
+			// Per-key colors override the layer color
+			color := k.GlowColor
+			if color == "" {
+				color = l.Color
+			}
+			if color != "" {
+				if layer.Colors == nil {
+					layer.Colors = make(map[string]string)
+				}
+				layer.Colors[idx] = color
+			}
+		}
+		keymap.Layers = append(keymap.Layers, layer)
+	}
+
+	return keymap, layout.Geometry, nil
+}
+
+// modifiers returns the modifiers enabled on an action, in wrapping order
+func (a *oryxAction) modifiers() []oryxModifier {
+	if len(a.Modifiers) == 0 {
+		return nil
+	}
+
+	// Oryx writes modifiers either as flags or as a list of flag names
+	enabled := map[string]bool{}
+	var flags map[string]bool
+	var names []string
+	if err := json.Unmarshal(a.Modifiers, &flags); err == nil {
+		enabled = flags
+	} else if err := json.Unmarshal(a.Modifiers, &names); err == nil {
+		for _, n := range names {
+			enabled[n] = true
+		}
+	}
+
+	var mods []oryxModifier
+	for _, m := range oryxModifiers {
+		if enabled[m.flag] {
+			mods = append(mods, m)
+		}
+	}
+	return mods
+}
+
+// qmk formats the action as a QMK keycode expression, e.g. LSFT(KC_A) or MO(1)
+func (a *oryxAction) qmk() string {
+	if a == nil || a.Code == "" {
+		return ""
+	}
+	code := a.Code
+	if a.Layer != nil && !strings.HasPrefix(code, "KC_") {
+		code += "(" + strconv.Itoa(*a.Layer) + ")"
+	}
+	mods := a.modifiers()
+	for i := len(mods) - 1; i >= 0; i-- {
+		code = mods[i].qmk + "(" + code + ")"
+	}
+	return code
+}
+
+// label returns the display label for the action
+func (a *oryxAction) label(layerTitle func(int) string) string {
+	if a == nil || a.Code == "" {
+		return ""
+	}
+
+	// Layer switches: MO, TG, TO, TT, OSL, DF
+	if a.Layer != nil && !strings.HasPrefix(a.Code, "KC_") {
+		short := formatLayerShort(layerTitle(*a.Layer))
+		if a.Code == "MO" {
+			return "[" + short + "]"
+		}
+		return a.Code + " " + short
+	}
+
+	label := qmkLabel(a.Code)
+	mods := a.modifiers()
+	for i := len(mods) - 1; i >= 0; i-- {
+		label = mods[i].prefix + label
+	}
+	return label
+}
+
+// qmkLabel formats a QMK keycode to a readable label
+func qmkLabel(code string) string {
+	switch code {
+	case "KC_TRANSPARENT", "KC_TRNS", "_______":
+		return "▽"
+	case "KC_NO", "XXXXXXX":
+		return ""
+	}
+
+	key := strings.TrimPrefix(code, "KC_")
+	if mapped, ok := qmkKeycodes[key]; ok {
+		key = mapped
+	}
+
+	// QMK digits are plain, ZMK's formatKey expects N1..N0
+	if len(key) == 1 && key[0] >= '0' && key[0] <= '9' {
+		return key
+	}
+	return formatKey(key)
+}
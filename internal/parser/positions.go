@@ -0,0 +1,227 @@
+package parser
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// How JoinPositions matched bindings to keys
+const (
+	JoinByPositionMap = "positionMap" // The layout's explicit position map
+	JoinByMatrix      = "matrix"      // [row, col] of the keymap's matrix transform against the layout's
+	JoinByIndex       = "index"       // Binding N on key index N
+)
+
+// KeyPositions maps the keys of a layout to the bindings of a keymap
+type KeyPositions struct {
+	Method    string `json:"method"`
+	Bindings  []int  `json:"bindings"`            // Binding index for each layout key index, -1 when unbound
+	Unmatched []int  `json:"unmatched,omitempty"` // Binding indices that land on no key
+}
+
+// parseKeymapMatrix reads the matrix transform of a ZMK keymap, returning the
+// [row, col] of each binding. The chosen zmk,matrix-transform is preferred
+// when a keymap defines several.
+func parseKeymapMatrix(content string) [][]int {
+	if !strings.Contains(content, "zmk,matrix-transform") {
+		return nil
+	}
+	tree := parseDeviceTree(content)
+	applyDTOverrides(tree)
+
+	var transforms []*dtNode
+	chosen := ""
+	walkDT(tree, func(n *dtNode) {
+		if n.Name == "chosen" {
+			if ref, ok := n.Props["zmk,matrix-transform"]; ok {
+				chosen = strings.TrimPrefix(strings.TrimSpace(ref), "&")
+			}
+		}
+		if dtString(n.Props["compatible"]) == "zmk,matrix-transform" {
+			transforms = append(transforms, n)
+		}
+	})
+	if len(transforms) == 0 {
+		return nil
+	}
+
+	transform := transforms[0]
+	if chosen != "" {
+		if n := findDTLabel(tree, chosen); n != nil {
+			transform = n
+		}
+	}
+	return parseMatrixTransform(transform)
+}
+
+// JoinPositions works out which binding of a keymap lands on each key of a
+// layout. An explicit position map wins, then matrix positions when both sides
+// have them, and array order as a last resort.
+func JoinPositions(layout *Layout, keymap *Keymap) KeyPositions {
+	keyCount := 0
+	hasMatrix := false
+	for _, key := range layout.Keys {
+		if key.Index >= keyCount {
+			keyCount = key.Index + 1
+		}
+		if key.Index >= 0 && len(key.Matrix) == 2 {
+			hasMatrix = true
+		}
+	}
+	bindingCount := keymap.BindingCount()
+
+	positions := KeyPositions{Bindings: make([]int, keyCount)}
+	for i := range positions.Bindings {
+		positions.Bindings[i] = -1
+	}
+	bound := make([]bool, bindingCount)
+
+	switch {
+	case len(layout.PositionMap) > 0:
+		positions.Method = JoinByPositionMap
+		for b, k := range layout.PositionMap {
+			if b < bindingCount && k >= 0 && k < keyCount {
+				positions.Bindings[k] = b
+				bound[b] = true
+			}
+		}
+
+	case hasMatrix && len(keymap.Matrix) > 0:
+		positions.Method = JoinByMatrix
+		byMatrix := make(map[[2]int]int)
+		for i, rc := range keymap.Matrix {
+			if len(rc) == 2 {
+				byMatrix[[2]int{rc[0], rc[1]}] = i
+			}
+		}
+		for _, key := range layout.Keys {
+			if key.Index < 0 || len(key.Matrix) != 2 {
+				continue
+			}
+			if b, ok := byMatrix[[2]int{key.Matrix[0], key.Matrix[1]}]; ok && b < bindingCount {
+				positions.Bindings[key.Index] = b
+				bound[b] = true
+			}
+		}
+
+	default:
+		positions.Method = JoinByIndex
+		for k := range positions.Bindings {
+			if k < bindingCount {
+				positions.Bindings[k] = k
+				bound[k] = true
+			}
+		}
+	}
+
+	for b, ok := range bound {
+		if !ok {
+			positions.Unmatched = append(positions.Unmatched, b)
+		}
+	}
+	return positions
+}
+
+// PositionsToMap turns a join into a position map: the key index each binding
+// lands on, -1 for bindings on no key
+func PositionsToMap(positions KeyPositions, bindingCount int) []int {
+	positionMap := make([]int, bindingCount)
+	for i := range positionMap {
+		positionMap[i] = -1
+	}
+	for k, b := range positions.Bindings {
+		if b >= 0 && b < bindingCount {
+			positionMap[b] = k
+		}
+	}
+	return positionMap
+}
+
+// BindLayout returns a copy of layout whose key indices are the binding
+// indices that land on them, so code that draws binding N on key index N
+// follows the join. Keys without a binding get index -1.
+func BindLayout(layout *Layout, positions KeyPositions) *Layout {
+	bound := *layout
+	bound.Keys = make([]PhysicalKey, len(layout.Keys))
+	bound.PositionMap = nil
+	for i, key := range layout.Keys {
+		if key.Index >= 0 {
+			key.Index = -1
+			if layout.Keys[i].Index < len(positions.Bindings) {
+				key.Index = positions.Bindings[layout.Keys[i].Index]
+			}
+		}
+		bound.Keys[i] = key
+	}
+	return &bound
+}
+
+// AutoMatchPositions builds a position map by pairing the cells of a keymap's
+// ASCII art (in binding order) with the nearest layout keys. Both sets of
+// centers are scaled to the unit square first, since art cells are much wider
+// than they are tall. Closest pairs are matched first.
+func AutoMatchPositions(layout *Layout, cells []ArtCell) []int {
+	type point struct {
+		index int
+		x, y  float64
+	}
+
+	var keys []point
+	for _, k := range layout.Keys {
+		if k.Index >= 0 {
+			c := k.Center()
+			keys = append(keys, point{k.Index, c.X, c.Y})
+		}
+	}
+	art := make([]point, len(cells))
+	for i, c := range cells {
+		art[i] = point{i, c.X, c.Y}
+	}
+
+	normalize := func(points []point) {
+		if len(points) == 0 {
+			return
+		}
+		minX, minY, maxX, maxY := points[0].x, points[0].y, points[0].x, points[0].y
+		for _, p := range points {
+			minX, maxX = math.Min(minX, p.x), math.Max(maxX, p.x)
+			minY, maxY = math.Min(minY, p.y), math.Max(maxY, p.y)
+		}
+		for i := range points {
+			if maxX > minX {
+				points[i].x = (points[i].x - minX) / (maxX - minX)
+			}
+			if maxY > minY {
+				points[i].y = (points[i].y - minY) / (maxY - minY)
+			}
+		}
+	}
+	normalize(keys)
+	normalize(art)
+
+	type pair struct {
+		cell, key int
+		dist      float64
+	}
+	var pairs []pair
+	for i, a := range art {
+		for j, k := range keys {
+			pairs = append(pairs, pair{i, j, math.Hypot(a.x-k.x, a.y-k.y)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].dist < pairs[j].dist })
+
+	positionMap := make([]int, len(cells))
+	for i := range positionMap {
+		positionMap[i] = -1
+	}
+	usedKey := make([]bool, len(keys))
+	for _, p := range pairs {
+		if positionMap[p.cell] == -1 && !usedKey[p.key] {
+			positionMap[p.cell] = keys[p.key].index
+			usedKey[p.key] = true
+		}
+	}
+	return positionMap
+}
@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// qmkInfo is the part of a QMK info.json / keyboard.json describing layouts
+type qmkInfo struct {
+	KeyboardName string `json:"keyboard_name"`
+	Layouts      map[string]struct {
+		Layout []struct {
+			Matrix []int   `json:"matrix"`
+			X      float64 `json:"x"`
+			Y      float64 `json:"y"`
+			W      float64 `json:"w"`
+			H      float64 `json:"h"`
+			R      float64 `json:"r"`
+			RX     float64 `json:"rx"`
+			RY     float64 `json:"ry"`
+			Label  string  `json:"label"`
+		} `json:"layout"`
+	} `json:"layouts"`
+}
+
+// IsQMKInfoLayout reports whether data looks like a QMK info.json rather than KLE raw data
+func IsQMKInfoLayout(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+	var probe struct {
+		Layouts map[string]json.RawMessage `json:"layouts"`
+	}
+	return json.Unmarshal(trimmed, &probe) == nil && len(probe.Layouts) > 0
+}
+
+// QMKLayoutVariants lists the LAYOUT_* names defined in a QMK info.json
+func QMKLayoutVariants(data []byte) ([]string, error) {
+	var info qmkInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	variants := make([]string, 0, len(info.Layouts))
+	for v := range info.Layouts {
+		variants = append(variants, v)
+	}
+	sort.Strings(variants)
+	return variants, nil
+}
+
+// ParseQMKInfoLayout parses one LAYOUT_* variant of a QMK info.json / keyboard.json.
+// An empty variant selects "LAYOUT" when present, otherwise the first variant by name.
+// An empty name falls back to the keyboard_name of the file.
+func ParseQMKInfoLayout(data []byte, name string, variant string) (*Layout, error) {
+	var info qmkInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	if len(info.Layouts) == 0 {
+		return nil, fmt.Errorf("no layouts defined")
+	}
+
+	if variant == "" {
+		variants, _ := QMKLayoutVariants(data)
+		variant = variants[0]
+		if _, ok := info.Layouts["LAYOUT"]; ok {
+			variant = "LAYOUT"
+		}
+	}
+	def, ok := info.Layouts[variant]
+	if !ok {
+		variants, _ := QMKLayoutVariants(data)
+		return nil, fmt.Errorf("unknown layout %q, available: %s", variant, strings.Join(variants, ", "))
+	}
+
+	if name == "" {
+		name = info.KeyboardName
+	}
+	layout := &Layout{
+		Name: name,
+		Keys: []PhysicalKey{},
+	}
+
+	// QMK keys use the same coordinates as KLE; width and height default to 1
+	for i, k := range def.Layout {
+		key := PhysicalKey{
+			X:      k.X,
+			Y:      k.Y,
+			W:      k.W,
+			H:      k.H,
+			R:      k.R,
+			RX:     k.RX,
+			RY:     k.RY,
+			Index:  i,
+			Matrix: k.Matrix,
+		}
+		if key.W == 0 {
+			key.W = 1
+		}
+		if key.H == 0 {
+			key.H = 1
+		}
+		if k.Label != "" {
+			key.Labels = make([]string, 12)
+			key.Labels[0] = k.Label
+		}
+		layout.Keys = append(layout.Keys, key)
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,282 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// rowTolerance is how far apart key centers may be vertically and still
+// count as one row when renumbering
+const rowTolerance = 0.5
+
+// copyLayout returns a copy of a layout whose keys and position map can be
+// changed without touching the original
+func copyLayout(layout *Layout) *Layout {
+	copied := *layout
+	copied.Keys = append([]PhysicalKey{}, layout.Keys...)
+	if layout.PositionMap != nil {
+		copied.PositionMap = append([]int{}, layout.PositionMap...)
+	}
+	return &copied
+}
+
+// translate moves a key and its rotation origin
+func (k *PhysicalKey) translate(dx, dy float64) {
+	k.X += dx
+	k.Y += dy
+	k.RX += dx
+	k.RY += dy
+}
+
+// maxIndex returns the highest key index of a layout, -1 when no key has one
+func maxIndex(layout *Layout) int {
+	max := -1
+	for _, k := range layout.Keys {
+		if k.Index > max {
+			max = k.Index
+		}
+	}
+	return max
+}
+
+// MirrorLayout flips a layout horizontally around the vertical line x = axis.
+// Rotations turn the other way; indices and matrix positions are kept.
+func MirrorLayout(layout *Layout, axis float64) *Layout {
+	mirrored := copyLayout(layout)
+	for i := range mirrored.Keys {
+		k := &mirrored.Keys[i]
+		if k.W2 > 0 && k.H2 > 0 {
+			k.X2 = k.W - k.X2 - k.W2
+		}
+		k.X = 2*axis - k.X - k.W
+		k.RX = 2*axis - k.RX
+		if k.R != 0 {
+			k.R = -k.R
+		}
+	}
+	return mirrored
+}
+
+// SplitLayout keeps the keys whose center lies left of x = axis, or right of
+// it when right is set. Indices are compacted in their original order.
+func SplitLayout(layout *Layout, axis float64, right bool) *Layout {
+	split := copyLayout(layout)
+	split.Keys = split.Keys[:0:0]
+	for _, k := range layout.Keys {
+		if (k.Center().X >= axis) == right {
+			split.Keys = append(split.Keys, k)
+		}
+	}
+	compactIndices(split)
+	return split
+}
+
+// MergeLayouts places right next to left with a gap (in key units) between
+// their extents. The keys of right are numbered after those of left, and their
+// matrix columns follow left's when both sides have matrix positions. The
+// merged layout has no position map.
+func MergeLayouts(left, right *Layout, gap float64) *Layout {
+	merged := copyLayout(left)
+	merged.PositionMap = nil
+
+	leftExtent := ComputeGeometry(left).Extent
+	rightExtent := ComputeGeometry(right).Extent
+	dx := leftExtent.X + leftExtent.W + gap - rightExtent.X
+
+	indexOffset := maxIndex(left) + 1
+	colOffset := -1
+	for _, k := range left.Keys {
+		if len(k.Matrix) == 2 && k.Matrix[1] > colOffset {
+			colOffset = k.Matrix[1]
+		}
+	}
+	colOffset++
+
+	for _, k := range right.Keys {
+		k.translate(dx, 0)
+		if k.Index >= 0 {
+			k.Index += indexOffset
+		}
+		if len(k.Matrix) == 2 && colOffset > 0 {
+			k.Matrix = []int{k.Matrix[0], k.Matrix[1] + colOffset}
+		}
+		merged.Keys = append(merged.Keys, k)
+	}
+	return merged
+}
+
+// RotateKeys turns the keys at the given positions of the layout's key list by
+// angle degrees around origin. The keys keep their shape and all share the
+// origin as their rotation center afterwards, so they stay one KLE cluster.
+func RotateKeys(layout *Layout, keys []int, angle float64, origin Point) (*Layout, error) {
+	rotated := copyLayout(layout)
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	for _, i := range keys {
+		if i < 0 || i >= len(rotated.Keys) {
+			return nil, fmt.Errorf("key %d out of range", i)
+		}
+		k := &rotated.Keys[i]
+
+		// Rotating about the old origin and then about the new one is the same as
+		// rotating by the sum about origin, after moving the key by a constant
+		ox, oy := k.RX-origin.X, k.RY-origin.Y
+		cos, sin := math.Cos(rad(-k.R)), math.Sin(rad(-k.R))
+		dx := origin.X - k.RX + ox*cos - oy*sin
+		dy := origin.Y - k.RY + ox*sin + oy*cos
+		k.X += dx
+		k.Y += dy
+		k.R = math.Mod(k.R+angle, 360)
+		k.RX, k.RY = origin.X, origin.Y
+		if k.R == 0 {
+			k.RX, k.RY = 0, 0
+		}
+	}
+	return rotated, nil
+}
+
+// keyRows groups the keys of a layout into rows from top to bottom, each
+// left to right, as positions in the key list. Keys whose centers are less
+// than half a key apart vertically share a row; decals and ghosts are left out.
+func keyRows(layout *Layout) [][]int {
+	type keyCenter struct {
+		pos int
+		c   Point
+	}
+	var centers []keyCenter
+	for i, k := range layout.Keys {
+		if k.Index >= 0 {
+			centers = append(centers, keyCenter{i, k.Center()})
+		}
+	}
+	sort.SliceStable(centers, func(a, b int) bool { return centers[a].c.Y < centers[b].c.Y })
+
+	var rows [][]int
+	for start := 0; start < len(centers); {
+		end := start + 1
+		for end < len(centers) && centers[end].c.Y-centers[start].c.Y < rowTolerance {
+			end++
+		}
+		row := centers[start:end]
+		sort.SliceStable(row, func(a, b int) bool { return row[a].c.X < row[b].c.X })
+		positions := make([]int, len(row))
+		for i, kc := range row {
+			positions[i] = kc.pos
+		}
+		rows = append(rows, positions)
+		start = end
+	}
+	return rows
+}
+
+// RenumberLayout renumbers keys by their position: rows from top to bottom,
+// keys left to right within a row (see keyRows). The position map follows
+// the new indices.
+func RenumberLayout(layout *Layout) *Layout {
+	renumbered := copyLayout(layout)
+	newIndex := make(map[int]int)
+	index := 0
+	for _, row := range keyRows(layout) {
+		for _, pos := range row {
+			newIndex[layout.Keys[pos].Index] = index
+			renumbered.Keys[pos].Index = index
+			index++
+		}
+	}
+	remapPositionMap(renumbered, newIndex)
+	return renumbered
+}
+
+// InsertKey adds a key to a layout. The key takes its own index and keys from
+// that index on move up by one; a key without an index (and not a decal or
+// ghost) is numbered after the last key.
+func InsertKey(layout *Layout, key PhysicalKey) *Layout {
+	inserted := copyLayout(layout)
+	if key.W == 0 {
+		key.W = 1
+	}
+	if key.H == 0 {
+		key.H = 1
+	}
+	next := maxIndex(layout) + 1
+	if key.Decal || key.Ghost {
+		key.Index = -1
+	} else if key.Index < 0 || key.Index > next {
+		key.Index = next
+	}
+
+	if key.Index >= 0 {
+		newIndex := make(map[int]int)
+		for i := range inserted.Keys {
+			k := &inserted.Keys[i]
+			if k.Index < 0 {
+				continue
+			}
+			newIndex[k.Index] = k.Index
+			if k.Index >= key.Index {
+				newIndex[k.Index]++
+				k.Index++
+			}
+		}
+		remapPositionMap(inserted, newIndex)
+	}
+	inserted.Keys = append(inserted.Keys, key)
+	return inserted
+}
+
+// RemoveKeys removes the keys at the given positions of the layout's key list.
+// The remaining indices are compacted in their original order.
+func RemoveKeys(layout *Layout, keys []int) (*Layout, error) {
+	remove := make(map[int]bool)
+	for _, i := range keys {
+		if i < 0 || i >= len(layout.Keys) {
+			return nil, fmt.Errorf("key %d out of range", i)
+		}
+		remove[i] = true
+	}
+
+	removed := copyLayout(layout)
+	removed.Keys = removed.Keys[:0:0]
+	for i, k := range layout.Keys {
+		if !remove[i] {
+			removed.Keys = append(removed.Keys, k)
+		}
+	}
+	compactIndices(removed)
+	return removed, nil
+}
+
+// compactIndices renumbers the keys of a layout 0..n-1 keeping their order by
+// index, so gaps left by removed keys close up
+func compactIndices(layout *Layout) {
+	var indices []int
+	for _, k := range layout.Keys {
+		if k.Index >= 0 {
+			indices = append(indices, k.Index)
+		}
+	}
+	sort.Ints(indices)
+
+	newIndex := make(map[int]int)
+	for i, index := range indices {
+		newIndex[index] = i
+	}
+	for i := range layout.Keys {
+		if k := &layout.Keys[i]; k.Index >= 0 {
+			k.Index = newIndex[k.Index]
+		}
+	}
+	remapPositionMap(layout, newIndex)
+}
+
+// remapPositionMap updates a layout's position map after its keys were
+// renumbered; bindings on keys missing from newIndex land on no key
+func remapPositionMap(layout *Layout, newIndex map[int]int) {
+	for b, k := range layout.PositionMap {
+		if index, ok := newIndex[k]; ok {
+			layout.PositionMap[b] = index
+		} else {
+			layout.PositionMap[b] = -1
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Kinds of layout warnings
+const (
+	WarnOverlap        = "overlap"        // Two keys cover the same area
+	WarnDuplicate      = "duplicate"      // Two keys sit at the same position
+	WarnOffGrid        = "offGrid"        // A key is off the grid the rest of the layout follows
+	WarnRotationOrigin = "rotationOrigin" // A rotation origin lies far outside the layout
+	WarnIndexConflict  = "indexConflict"  // Two keys share an index or matrix position
+	WarnIndexGap       = "indexGap"       // An index no key uses
+)
+
+// LayoutWarning is a problem found in a layout by ValidateLayout
+type LayoutWarning struct {
+	Kind    string `json:"kind"`
+	Keys    []int  `json:"keys,omitempty"` // Positions in the layout's key list
+	Message string `json:"message"`
+}
+
+const (
+	// layoutGrid is the grid KLE layouts are usually drawn on
+	layoutGrid = 0.25
+	// gridTolerance is how far off the grid a coordinate may be
+	gridTolerance = 0.01
+	// overlapTolerance ignores overlaps thinner than this, e.g. from rounding
+	overlapTolerance = 0.02
+	// originMargin is how far outside the layout a rotation origin may lie
+	originMargin = 2.0
+)
+
+// keyRects returns the rotated rectangles of a key: the primary one and the
+// secondary one of ISO enters and stepped caps. Unlike the outline, each is convex.
+func (k PhysicalKey) keyRects() [][]Point {
+	rects := []Rect{{k.X, k.Y, k.W, k.H}}
+	if k.W2 > 0 && k.H2 > 0 {
+		rects = append(rects, Rect{k.X + k.X2, k.Y + k.Y2, k.W2, k.H2})
+	}
+	polys := make([][]Point, len(rects))
+	for i, r := range rects {
+		polys[i] = []Point{
+			k.Rotate(r.X, r.Y),
+			k.Rotate(r.X+r.W, r.Y),
+			k.Rotate(r.X+r.W, r.Y+r.H),
+			k.Rotate(r.X, r.Y+r.H),
+		}
+	}
+	return polys
+}
+
+// convexOverlap reports whether two convex polygons overlap by more than
+// tolerance, using the separating axis theorem
+func convexOverlap(a, b []Point, tolerance float64) bool {
+	for _, poly := range [][]Point{a, b} {
+		for i := range poly {
+			p, q := poly[i], poly[(i+1)%len(poly)]
+			nx, ny := q.Y-p.Y, p.X-q.X
+			length := math.Hypot(nx, ny)
+			if length == 0 {
+				continue
+			}
+			nx, ny = nx/length, ny/length
+
+			project := func(points []Point) (float64, float64) {
+				lo, hi := math.Inf(1), math.Inf(-1)
+				for _, pt := range points {
+					d := pt.X*nx + pt.Y*ny
+					lo, hi = math.Min(lo, d), math.Max(hi, d)
+				}
+				return lo, hi
+			}
+			aLo, aHi := project(a)
+			bLo, bHi := project(b)
+			if math.Min(aHi, bHi)-math.Max(aLo, bLo) <= tolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// onGrid reports whether v is a multiple of the layout grid
+func onGrid(v float64) bool {
+	return math.Abs(v/layoutGrid-math.Round(v/layoutGrid))*layoutGrid <= gridTolerance
+}
+
+// ValidateLayout lints a layout: keys that overlap or sit on top of each
+// other, keys off the grid the rest of the layout follows, rotation origins
+// far outside the layout, and conflicting or missing key indices. Decals and
+// ghost keys are only checked for their rotation origin, and alternative
+// choices of a layout option may overlap and share a matrix position.
+func ValidateLayout(layout *Layout) []LayoutWarning {
+	warnings := []LayoutWarning{}
+	label := func(i int) string {
+		k := layout.Keys[i]
+		if legend := k.PrimaryLabel(); legend != "" {
+			return fmt.Sprintf("key %d (%q)", k.Index, legend)
+		}
+		return fmt.Sprintf("key %d", k.Index)
+	}
+
+	var real []int
+	for i, k := range layout.Keys {
+		if !k.Decal && !k.Ghost {
+			real = append(real, i)
+		}
+	}
+
+	// Overlaps and duplicates
+	rects := make([][][]Point, len(layout.Keys))
+	centers := make([]Point, len(layout.Keys))
+	for _, i := range real {
+		rects[i] = layout.Keys[i].keyRects()
+		centers[i] = layout.Keys[i].Center()
+	}
+	for a := 0; a < len(real); a++ {
+		for b := a + 1; b < len(real); b++ {
+			i, j := real[a], real[b]
+			if layout.Keys[i].alternativeTo(layout.Keys[j]) {
+				continue
+			}
+			if math.Hypot(centers[i].X-centers[j].X, centers[i].Y-centers[j].Y) < gridTolerance {
+				warnings = append(warnings, LayoutWarning{
+					Kind:    WarnDuplicate,
+					Keys:    []int{i, j},
+					Message: fmt.Sprintf("%s and %s are at the same position", label(i), label(j)),
+				})
+				continue
+			}
+			overlap := false
+			for _, ra := range rects[i] {
+				for _, rb := range rects[j] {
+					if convexOverlap(ra, rb, overlapTolerance) {
+						overlap = true
+					}
+				}
+			}
+			if overlap {
+				warnings = append(warnings, LayoutWarning{
+					Kind:    WarnOverlap,
+					Keys:    []int{i, j},
+					Message: fmt.Sprintf("%s overlaps %s", label(i), label(j)),
+				})
+			}
+		}
+	}
+
+	// Off-grid keys, only for layouts drawn on the grid in the first place
+	var straight, gridded []int
+	for _, i := range real {
+		k := layout.Keys[i]
+		if k.R != 0 {
+			continue
+		}
+		straight = append(straight, i)
+		if onGrid(k.X) && onGrid(k.Y) && onGrid(k.W) && onGrid(k.H) {
+			gridded = append(gridded, i)
+		}
+	}
+	if len(straight) > 0 && len(gridded)*5 >= len(straight)*4 && len(gridded) < len(straight) {
+		isGridded := make(map[int]bool)
+		for _, i := range gridded {
+			isGridded[i] = true
+		}
+		for _, i := range straight {
+			if !isGridded[i] {
+				k := layout.Keys[i]
+				warnings = append(warnings, LayoutWarning{
+					Kind:    WarnOffGrid,
+					Keys:    []int{i},
+					Message: fmt.Sprintf("%s at %g,%g (%gx%g) is off the %gu grid", label(i), k.X, k.Y, k.W, k.H, layoutGrid),
+				})
+			}
+		}
+	}
+
+	// Rotation origins far from everything
+	extent := ComputeGeometry(layout).Extent
+	for i, k := range layout.Keys {
+		if k.R == 0 {
+			continue
+		}
+		if k.RX < extent.X-originMargin || k.RX > extent.X+extent.W+originMargin ||
+			k.RY < extent.Y-originMargin || k.RY > extent.Y+extent.H+originMargin {
+			warnings = append(warnings, LayoutWarning{
+				Kind:    WarnRotationOrigin,
+				Keys:    []int{i},
+				Message: fmt.Sprintf("%s rotates around %g,%g, far outside the layout", label(i), k.RX, k.RY),
+			})
+		}
+	}
+
+	// Index and matrix conflicts, and gaps in the numbering
+	byIndex := make(map[int][]int)
+	byMatrix := make(map[[2]int][]int)
+	maxIndex := -1
+	for _, i := range real {
+		k := layout.Keys[i]
+		if k.Index < 0 {
+			continue
+		}
+		byIndex[k.Index] = append(byIndex[k.Index], i)
+		if k.Index > maxIndex {
+			maxIndex = k.Index
+		}
+		if len(k.Matrix) == 2 {
+			rc := [2]int{k.Matrix[0], k.Matrix[1]}
+			byMatrix[rc] = append(byMatrix[rc], i)
+		}
+	}
+	for index := 0; index <= maxIndex; index++ {
+		keys := byIndex[index]
+		switch {
+		case len(keys) == 0:
+			warnings = append(warnings, LayoutWarning{
+				Kind:    WarnIndexGap,
+				Message: fmt.Sprintf("no key has index %d", index),
+			})
+		case len(keys) > 1:
+			warnings = append(warnings, LayoutWarning{
+				Kind:    WarnIndexConflict,
+				Keys:    keys,
+				Message: fmt.Sprintf("%d keys share index %d", len(keys), index),
+			})
+		}
+	}
+	var conflicts [][2]int
+	for rc, keys := range byMatrix {
+		// Alternative choices of a layout option share the matrix position
+		conflict := false
+		for a := 0; a < len(keys); a++ {
+			for b := a + 1; b < len(keys); b++ {
+				if !layout.Keys[keys[a]].alternativeTo(layout.Keys[keys[b]]) {
+					conflict = true
+				}
+			}
+		}
+		if conflict {
+			conflicts = append(conflicts, rc)
+		}
+	}
+	sort.Slice(conflicts, func(a, b int) bool {
+		return conflicts[a][0] < conflicts[b][0] || (conflicts[a][0] == conflicts[b][0] && conflicts[a][1] < conflicts[b][1])
+	})
+	for _, rc := range conflicts {
+		warnings = append(warnings, LayoutWarning{
+			Kind:    WarnIndexConflict,
+			Keys:    byMatrix[rc],
+			Message: fmt.Sprintf("%d keys share matrix position %d,%d", len(byMatrix[rc]), rc[0], rc[1]),
+		})
+	}
+
+	return warnings
+}
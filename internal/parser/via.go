@@ -0,0 +1,257 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VIA and Vial put "row,col" in a key's top-left legend and "group,choice" of
+// a layout option in its bottom-right legend
+const (
+	viaMatrixSlot = 0
+	viaOptionSlot = 8
+)
+
+var viaPairRegex = regexp.MustCompile(`^\s*(\d+)\s*,\s*(\d+)\s*$`)
+
+// LayoutVariant is a group of alternative physical options, e.g. split
+// backspace or ISO enter. Keys belonging to choice N of the group have
+// Option [group, N]; choice 0 is the default.
+type LayoutVariant struct {
+	Group    int      `json:"group"`
+	Name     string   `json:"name,omitempty"`
+	Choices  []string `json:"choices"`  // Choice names, numbered when the file has none
+	Selected int      `json:"selected"` // Choice currently shown
+}
+
+// viaPair parses a "a,b" legend
+func viaPair(legend string) ([]int, bool) {
+	m := viaPairRegex.FindStringSubmatch(legend)
+	if m == nil {
+		return nil, false
+	}
+	a, _ := strconv.Atoi(m[1])
+	b, _ := strconv.Atoi(m[2])
+	return []int{a, b}, true
+}
+
+// applyVIALegends turns the matrix and option legends of a VIA/Vial style KLE
+// layout into matrix positions and layout variants. Layouts where any real key
+// lacks a "row,col" legend are left alone.
+func applyVIALegends(layout *Layout) {
+	real := 0
+	for _, k := range layout.Keys {
+		if k.Decal || k.Ghost {
+			continue
+		}
+		if len(k.Labels) <= viaMatrixSlot {
+			return
+		}
+		if _, ok := viaPair(k.Labels[viaMatrixSlot]); !ok {
+			return
+		}
+		real++
+	}
+	if real == 0 {
+		return
+	}
+
+	choices := make(map[int]int)
+	for i := range layout.Keys {
+		k := &layout.Keys[i]
+		if k.Decal || k.Ghost {
+			continue
+		}
+		k.Matrix, _ = viaPair(k.Labels[viaMatrixSlot])
+		k.Labels[viaMatrixSlot] = ""
+		if option, ok := viaPair(k.Labels[viaOptionSlot]); ok {
+			k.Option = option
+			k.Labels[viaOptionSlot] = ""
+			if option[1]+1 > choices[option[0]] {
+				choices[option[0]] = option[1] + 1
+			}
+		}
+		if strings.Join(k.Labels, "") == "" {
+			k.Labels = nil
+		}
+	}
+
+	groups := make([]int, 0, len(choices))
+	for group := range choices {
+		groups = append(groups, group)
+	}
+	sort.Ints(groups)
+	for _, group := range groups {
+		variant := LayoutVariant{Group: group}
+		for c := 0; c < choices[group]; c++ {
+			variant.Choices = append(variant.Choices, strconv.Itoa(c))
+		}
+		layout.Variants = append(layout.Variants, variant)
+	}
+}
+
+// IsVIADefinition reports whether data looks like a VIA or Vial keyboard
+// definition, whose layouts object holds KLE data under "keymap"
+func IsVIADefinition(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return false
+	}
+	var probe struct {
+		Layouts struct {
+			Keymap []json.RawMessage `json:"keymap"`
+		} `json:"layouts"`
+	}
+	return json.Unmarshal(trimmed, &probe) == nil && len(probe.Layouts.Keymap) > 0
+}
+
+// ParseVIADefinition parses the KLE layout of a VIA or Vial definition (via.json,
+// vial.json), naming its layout options from the definition's labels. A label
+// is either the name of an on/off option or a list of the name and the choices.
+// An empty name falls back to the name of the keyboard.
+func ParseVIADefinition(data []byte, name string) (*Layout, error) {
+	var def struct {
+		Name    string `json:"name"`
+		Layouts struct {
+			Labels []interface{}   `json:"labels"`
+			Keymap json.RawMessage `json:"keymap"`
+		} `json:"layouts"`
+	}
+	if err := json.Unmarshal(data, &def); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = def.Name
+	}
+
+	layout, err := ParseKLELayout(def.Layouts.Keymap, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for group, label := range def.Layouts.Labels {
+		variant := LayoutVariant{Group: group}
+		switch l := label.(type) {
+		case string:
+			variant.Name = l
+			variant.Choices = []string{"Off", "On"}
+		case []interface{}:
+			for i, item := range l {
+				s, _ := item.(string)
+				if i == 0 {
+					variant.Name = s
+				} else {
+					variant.Choices = append(variant.Choices, s)
+				}
+			}
+		default:
+			continue
+		}
+
+		found := false
+		for i := range layout.Variants {
+			if layout.Variants[i].Group == group {
+				layout.Variants[i].Name = variant.Name
+				if len(variant.Choices) >= len(layout.Variants[i].Choices) {
+					layout.Variants[i].Choices = variant.Choices
+				}
+				found = true
+			}
+		}
+		if !found {
+			layout.Variants = append(layout.Variants, variant)
+		}
+	}
+	sort.Slice(layout.Variants, func(a, b int) bool { return layout.Variants[a].Group < layout.Variants[b].Group })
+
+	return layout, nil
+}
+
+// ParseVariantSelection parses a variant combination such as "0:1,2:0", a
+// list of group:choice pairs
+func ParseVariantSelection(s string) (map[int]int, error) {
+	selection := make(map[int]int)
+	if strings.TrimSpace(s) == "" {
+		return selection, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		g, c, ok := strings.Cut(pair, ":")
+		group, err1 := strconv.Atoi(strings.TrimSpace(g))
+		choice, err2 := strconv.Atoi(strings.TrimSpace(c))
+		if !ok || err1 != nil || err2 != nil || group < 0 || choice < 0 {
+			return nil, fmt.Errorf("invalid variant %q, expected group:choice", pair)
+		}
+		selection[group] = choice
+	}
+	return selection, nil
+}
+
+// SelectVariant returns the layout as built with the given choice for each
+// option group, choice 0 for groups not in the selection. Groups the layout
+// doesn't have are an error, like choices it doesn't have. Keys of other
+// choices are dropped, and the selected keys move so their top-left corner
+// lines up with that of the default choice, as VIA does. Key indices are kept.
+func SelectVariant(layout *Layout, selection map[int]int) (*Layout, error) {
+	selected := copyLayout(layout)
+	selected.Variants = append([]LayoutVariant{}, layout.Variants...)
+	for i, v := range selected.Variants {
+		choice := selection[v.Group]
+		if choice >= len(v.Choices) {
+			return nil, fmt.Errorf("option %d has no choice %d", v.Group, choice)
+		}
+		selected.Variants[i].Selected = choice
+	}
+	for group := range selection {
+		found := false
+		for _, v := range layout.Variants {
+			found = found || v.Group == group
+		}
+		if !found {
+			return nil, fmt.Errorf("layout has no option %d", group)
+		}
+	}
+
+	// Keys of each (group, choice)
+	byOption := make(map[[2]int][]PhysicalKey)
+	for _, k := range layout.Keys {
+		if len(k.Option) == 2 {
+			option := [2]int{k.Option[0], k.Option[1]}
+			byOption[option] = append(byOption[option], k)
+		}
+	}
+	offsets := make(map[int]Point)
+	for group, choice := range selection {
+		if choice == 0 || len(byOption[[2]int{group, choice}]) == 0 || len(byOption[[2]int{group, 0}]) == 0 {
+			continue
+		}
+		def := ComputeGeometry(&Layout{Keys: byOption[[2]int{group, 0}]}).Extent
+		alt := ComputeGeometry(&Layout{Keys: byOption[[2]int{group, choice}]}).Extent
+		offsets[group] = Point{def.X - alt.X, def.Y - alt.Y}
+	}
+
+	selected.Keys = selected.Keys[:0:0]
+	for _, k := range layout.Keys {
+		if len(k.Option) == 2 {
+			if k.Option[1] != selection[k.Option[0]] {
+				continue
+			}
+			if offset, ok := offsets[k.Option[0]]; ok {
+				k.translate(offset.X, offset.Y)
+			}
+		}
+		selected.Keys = append(selected.Keys, k)
+	}
+	return selected, nil
+}
+
+// alternativeTo reports whether two keys are different choices of the same
+// layout option, so they never exist together
+func (k PhysicalKey) alternativeTo(other PhysicalKey) bool {
+	return len(k.Option) == 2 && len(other.Option) == 2 &&
+		k.Option[0] == other.Option[0] && k.Option[1] != other.Option[1]
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Devicetree compatibles of the ZMK nodes read by parseKeymapBehaviors
+const (
+	zmkCombosCompatible = "zmk,combos"
+	zmkMacroCompatible  = "zmk,behavior-macro" // Also matches the one- and two-param variants
+	zmkLeaderCompatible = "zmk,behavior-leader-key"
+)
+
+// parseKeymapBehaviors reads the combos, macros and leader sequences defined
+// in the devicetree of a ZMK keymap. Combo layers are given as indices into
+// the keymap's layers, which must be parsed already.
+func parseKeymapBehaviors(content string, keymap *Keymap) {
+	if !strings.Contains(content, zmkCombosCompatible) && !strings.Contains(content, zmkMacroCompatible) &&
+		!strings.Contains(content, zmkLeaderCompatible) {
+		return
+	}
+	tree := parseDeviceTree(content)
+	applyDTOverrides(tree)
+
+	walkDT(tree, func(n *dtNode) {
+		compatible := dtString(n.Props["compatible"])
+		switch {
+		case compatible == zmkCombosCompatible:
+			for _, child := range n.Children {
+				if combo, ok := parseZMKCombo(child, keymap.Layers); ok {
+					keymap.Combos = append(keymap.Combos, combo)
+				}
+			}
+
+		case strings.HasPrefix(compatible, zmkMacroCompatible):
+			name := n.Label
+			if name == "" {
+				name = n.Name
+			}
+			keymap.Macros = append(keymap.Macros, Macro{Name: name, Bindings: dtBindings(n.Props["bindings"])})
+
+		case compatible == zmkLeaderCompatible:
+			for _, child := range n.Children {
+				macro := Macro{Name: child.Name, Bindings: dtBindings(child.Props["bindings"])}
+				for _, key := range dtCells(child.Props["sequence"]) {
+					macro.Sequence = append(macro.Sequence, formatKey(key))
+				}
+				keymap.Macros = append(keymap.Macros, macro)
+			}
+		}
+	})
+}
+
+// parseZMKCombo reads a child node of a zmk,combos node
+func parseZMKCombo(n *dtNode, layers []Layer) (Combo, bool) {
+	bindings := dtBindings(n.Props["bindings"])
+	if len(bindings) == 0 {
+		return Combo{}, false
+	}
+	combo := Combo{Label: convertBinding(bindings[0])}
+	for _, cell := range dtCells(n.Props["key-positions"]) {
+		if pos, ok := dtInt(cell); ok {
+			combo.Positions = append(combo.Positions, pos)
+		}
+	}
+	for _, cell := range dtCells(n.Props["layers"]) {
+		if i, ok := dtInt(cell); ok && i >= 0 && i < len(layers) {
+			combo.Layers = append(combo.Layers, layers[i].Name)
+		}
+	}
+	return combo, len(combo.Positions) > 0
+}
+
+// dtBindings splits a bindings property into its bindings, e.g.
+// "<&kp A>, <&mo 1>" into "&kp A" and "&mo 1"
+func dtBindings(value string) []string {
+	var bindings []string
+	for _, cell := range dtCells(value) {
+		if strings.HasPrefix(cell, "&") || len(bindings) == 0 {
+			bindings = append(bindings, cell)
+		} else {
+			bindings[len(bindings)-1] += " " + cell
+		}
+	}
+	return bindings
+}
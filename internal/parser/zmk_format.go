@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// columnGutter is the space between binding columns of a formatted keymap
+const columnGutter = 2
+
+// bindingItem is a binding or a comment in the binding list of a layer
+type bindingItem struct {
+	text    string // Binding with its parameters, or the comment as written
+	comment bool
+	ownLine bool // Comment that starts its line
+}
+
+// keyCell is where a binding goes in a formatted keymap
+type keyCell struct {
+	row, col int
+}
+
+// FormatKeymap lines up the bindings of every layer of a ZMK keymap source
+// like the keys of a layout: one line per row of keys, each binding padded to
+// the column of its key. Columns are shared by all layers. layout's key
+// indices must be binding indices, as returned by BindLayout. Bindings keep
+// their order, so a row the keymap lists out of order takes several lines;
+// bindings without a key go on lines of their own. Comments in the binding
+// lists are kept, and everything outside them stays byte for byte.
+func FormatKeymap(content string, layout *Layout) string {
+	cells := keyCells(layout)
+	newline := "\n"
+	if strings.Contains(content, "\r\n") {
+		newline = "\r\n"
+	}
+
+	sites := keymapLayerSites(content)
+	items := make([][]bindingItem, len(sites))
+	var widths []int
+	for i, site := range sites {
+		span := content[site.BindingsStart:site.BindingsEnd]
+		// Bindings split into several <...> groups, or written with macros, are left alone
+		if strings.ContainsAny(maskComments(span), "<>") {
+			continue
+		}
+		var ok bool
+		if items[i], ok = bindingItems(span); !ok {
+			continue
+		}
+		binding := 0
+		for _, item := range items[i] {
+			if item.comment {
+				continue
+			}
+			if cell, ok := cells[binding]; ok {
+				for len(widths) <= cell.col {
+					widths = append(widths, 0)
+				}
+				widths[cell.col] = max(widths[cell.col], utf8.RuneCountInString(item.text))
+			}
+			binding++
+		}
+	}
+	offsets := make([]int, len(widths))
+	for c := 1; c < len(widths); c++ {
+		offsets[c] = offsets[c-1] + widths[c-1] + columnGutter
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, site := range sites {
+		if items[i] == nil {
+			continue
+		}
+		outer := lineIndent(content, site.BindingsStart)
+		inner := outer + "    "
+		if strings.Contains(outer, "\t") {
+			inner = outer + "\t"
+		}
+		b.WriteString(content[last:site.BindingsStart])
+		b.WriteString(newline)
+		for _, line := range formatBindings(items[i], cells, offsets) {
+			if line != "" {
+				b.WriteString(inner + line)
+			}
+			b.WriteString(newline)
+		}
+		b.WriteString(outer)
+		last = site.BindingsEnd
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// formatBindings lays out the binding list of a layer as lines, without indentation
+func formatBindings(items []bindingItem, cells map[int]keyCell, offsets []int) []string {
+	var lines []string
+	var line strings.Builder
+	width := 0 // Runes on the current line
+	prev := keyCell{-1, -1}
+	open := false // The current line can take more bindings
+	flush := func() {
+		if width > 0 {
+			lines = append(lines, line.String())
+		}
+		line.Reset()
+		width = 0
+		open = false
+	}
+	write := func(s string) {
+		line.WriteString(s)
+		width += utf8.RuneCountInString(s)
+	}
+
+	binding := 0
+	for _, item := range items {
+		if item.comment {
+			if item.ownLine {
+				flush()
+				lines = append(lines, item.text)
+			} else {
+				write(" " + item.text)
+				flush()
+			}
+			continue
+		}
+
+		cell, placed := cells[binding]
+		binding++
+		if !placed {
+			cell = keyCell{-1, -1}
+		}
+		if open && (cell.row != prev.row || (placed && cell.col <= prev.col)) {
+			flush()
+		}
+		switch {
+		case placed:
+			if width > offsets[cell.col] {
+				flush()
+			}
+			write(strings.Repeat(" ", offsets[cell.col]-width))
+		case width > 0:
+			write(strings.Repeat(" ", columnGutter))
+		}
+		write(item.text)
+		prev = cell
+		open = true
+	}
+	flush()
+	return lines
+}
+
+// keyCells places the binding of each key of a layout in a row and a column.
+// Rows are those of keyRows; keys less than half a key apart horizontally
+// share a column, unless they are in the same row.
+func keyCells(layout *Layout) map[int]keyCell {
+	cells := make(map[int]keyCell)
+	var columns []float64 // Center X of each column, left to right
+	for r, row := range keyRows(layout) {
+		next := 0
+		for _, pos := range row {
+			key := layout.Keys[pos]
+			x := key.Center().X
+			for next < len(columns) && columns[next] <= x-rowTolerance {
+				next++
+			}
+			if next == len(columns) || math.Abs(columns[next]-x) >= rowTolerance {
+				columns = append(columns, 0)
+				copy(columns[next+1:], columns[next:])
+				columns[next] = x
+				// Columns right of the new one move over
+				for binding, cell := range cells {
+					if cell.col >= next {
+						cell.col++
+						cells[binding] = cell
+					}
+				}
+			}
+			if _, ok := cells[key.Index]; !ok {
+				cells[key.Index] = keyCell{r, next}
+			}
+			next++
+		}
+	}
+	return cells
+}
+
+// bindingItems splits the binding list of a layer into bindings and
+// comments. It returns false when some text is neither, such as a macro
+// standing for a binding.
+func bindingItems(span string) ([]bindingItem, bool) {
+	var items []bindingItem
+	code := maskComments(span)
+	lineStart := true
+	for i := 0; i < len(span); {
+		c := span[i]
+		switch {
+		case c == '\n':
+			lineStart = true
+			i++
+		case isSpace(c):
+			i++
+		case code[i] == ' ':
+			// Comments and preprocessor directives are blanked out in code
+			var end int
+			if strings.HasPrefix(span[i:], "/*") {
+				end = i + strings.Index(span[i:], "*/") + 2
+			} else {
+				end = i
+				for end < len(span) && !(span[end] == '\n' && span[end-1] != '\\') {
+					end++
+				}
+			}
+			text := strings.TrimRight(span[i:end], " \t\r")
+			items = append(items, bindingItem{text: text, comment: true, ownLine: lineStart})
+			lineStart = false
+			i = end
+		case c == '&':
+			// A binding runs to the next binding or comment
+			end := i + 1
+			for end < len(span) && code[end] != '&' && !(code[end] == ' ' && !isSpace(span[end])) {
+				end++
+			}
+			items = append(items, bindingItem{text: strings.Join(strings.Fields(span[i:end]), " ")})
+			lineStart = false
+			i = end
+		default:
+			return nil, false
+		}
+	}
+	return items, true
+}
+
+// isSpace reports whether c is whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// lineIndent returns the indentation of the line holding offset
+func lineIndent(content string, offset int) string {
+	start := strings.LastIndex(content[:offset], "\n") + 1
+	line := content[start:]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
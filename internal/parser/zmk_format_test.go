@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// outsideBindings returns a keymap source with the binding list of every
+// layer cut out, which formatting must leave alone
+func outsideBindings(content string) string {
+	var b strings.Builder
+	last := 0
+	for _, site := range keymapLayerSites(content) {
+		b.WriteString(content[last:site.BindingsStart])
+		b.WriteString("<bindings>")
+		last = site.BindingsEnd
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// exampleLayout returns the bundled layout of the example keymap, bound to it
+func exampleLayout(t *testing.T, keymap *Keymap) *Layout {
+	t.Helper()
+	data, err := os.ReadFile("../../layouts/kinesis-advantage2.kle.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		t.Fatal(err)
+	}
+	return BindLayout(&layout, JoinPositions(&layout, keymap))
+}
+
+func TestFormatKeymapExample(t *testing.T) {
+	data, err := os.ReadFile("../../input/example.keymap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := string(data)
+	keymap, err := ParseKeymap(source, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := exampleLayout(t, keymap)
+
+	formatted := FormatKeymap(source, layout)
+	if formatted == source {
+		t.Fatal("formatting left the example unchanged")
+	}
+	if got, want := outsideBindings(formatted), outsideBindings(source); got != want {
+		t.Errorf("formatting changed the source outside binding lists:\n%s", got)
+	}
+	reparsed, err := ParseKeymap(formatted, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reparsed.Layers, keymap.Layers) {
+		t.Error("formatting changed the parsed layers")
+	}
+	if again := FormatKeymap(formatted, layout); again != formatted {
+		t.Errorf("formatting again changed the source:\n%s", again)
+	}
+
+	crlf := func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }
+	if got := FormatKeymap(crlf(source), layout); got != crlf(formatted) {
+		t.Errorf("CRLF source formatted as:\n%q", got)
+	}
+}
+
+// TestFormatKeymapComments checks that comments in a binding list keep their
+// place: on a line of their own, or after the binding they follow
+func TestFormatKeymapComments(t *testing.T) {
+	layout := &Layout{Keys: []PhysicalKey{
+		{X: 0, Y: 0, W: 1, H: 1, Index: 0}, {X: 1, Y: 0, W: 1, H: 1, Index: 1},
+		{X: 0, Y: 1, W: 1, H: 1, Index: 2}, {X: 1, Y: 1, W: 1, H: 1, Index: 3},
+	}}
+	source := `/ {
+	keymap {
+		compatible = "zmk,keymap";
+		base {
+			bindings = <
+			// Top row
+			&kp ESCAPE &kp B /* Layer key: */ &mo 1
+			&kp C
+			>;
+		};
+	};
+};
+`
+	want := `/ {
+	keymap {
+		compatible = "zmk,keymap";
+		base {
+			bindings = <
+				// Top row
+				&kp ESCAPE  &kp B /* Layer key: */
+				&mo 1       &kp C
+			>;
+		};
+	};
+};
+`
+	if got := FormatKeymap(source, layout); got != want {
+		t.Errorf("formatted source:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// zmkPhysicalLayoutCompatible marks ZMK physical layout nodes in devicetree
+const zmkPhysicalLayoutCompatible = "zmk,physical-layout"
+
+// IsZMKPhysicalLayout reports whether data is devicetree source defining a ZMK physical layout
+func IsZMKPhysicalLayout(data []byte) bool {
+	return strings.Contains(string(data), `"`+zmkPhysicalLayoutCompatible+`"`)
+}
+
+// zmkPhysicalLayoutNodes returns the physical layout nodes of a devicetree source
+// together with the label of the chosen one, if any
+func zmkPhysicalLayoutNodes(data []byte) ([]*dtNode, []*dtNode, string) {
+	tree := parseDeviceTree(string(data))
+	applyDTOverrides(tree)
+
+	var layouts []*dtNode
+	chosen := ""
+	walkDT(tree, func(n *dtNode) {
+		if n.Name == "chosen" {
+			if ref, ok := n.Props["zmk,physical-layout"]; ok {
+				chosen = strings.TrimPrefix(strings.TrimSpace(ref), "&")
+			}
+		}
+		if dtString(n.Props["compatible"]) == zmkPhysicalLayoutCompatible {
+			layouts = append(layouts, n)
+		}
+	})
+	return tree, layouts, chosen
+}
+
+// zmkLayoutID returns the name a physical layout node is selected by: its label, or its node name
+func zmkLayoutID(n *dtNode) string {
+	if n.Label != "" {
+		return n.Label
+	}
+	return n.Name
+}
+
+// ZMKPhysicalLayouts returns the labels of the physical layouts defined in a devicetree source
+func ZMKPhysicalLayouts(data []byte) ([]string, error) {
+	_, layouts, _ := zmkPhysicalLayoutNodes(data)
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("no %s nodes found", zmkPhysicalLayoutCompatible)
+	}
+	ids := make([]string, len(layouts))
+	for i, n := range layouts {
+		ids[i] = zmkLayoutID(n)
+	}
+	return ids, nil
+}
+
+// ParseZMKPhysicalLayout parses a zmk,physical-layout node from a devicetree
+// source (.dtsi, .overlay) into a Layout. Keys are read from the
+// <&key_physical_attrs w h x y r rx ry> entries, which are in hundredths of a
+// key unit and hundredths of a degree. The matrix position of each key comes
+// from the map of the matrix transform the layout references.
+//
+// selected picks the layout by label, node name or display-name; when empty
+// the layout from the chosen node is used, or else the first one.
+func ParseZMKPhysicalLayout(data []byte, name string, selected string) (*Layout, error) {
+	tree, layouts, chosen := zmkPhysicalLayoutNodes(data)
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("no %s nodes found", zmkPhysicalLayoutCompatible)
+	}
+
+	if selected == "" {
+		selected = chosen
+	}
+	node := layouts[0]
+	if selected != "" {
+		node = nil
+		for _, n := range layouts {
+			if n.Label == selected || n.Name == selected || dtString(n.Props["display-name"]) == selected {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			ids, _ := ZMKPhysicalLayouts(data)
+			return nil, fmt.Errorf("unknown physical layout %q, available: %s", selected, strings.Join(ids, ", "))
+		}
+	}
+
+	if name == "" {
+		name = dtString(node.Props["display-name"])
+	}
+	layout := &Layout{
+		Name: name,
+		Keys: []PhysicalKey{},
+	}
+
+	// Every key is a phandle followed by seven cells
+	cells := dtCells(node.Props["keys"])
+	for i := 0; i < len(cells); {
+		if !strings.HasPrefix(cells[i], "&") {
+			return nil, fmt.Errorf("unexpected cell %q in keys", cells[i])
+		}
+		if i+7 >= len(cells) {
+			return nil, fmt.Errorf("key %d has fewer than 7 attributes", len(layout.Keys))
+		}
+
+		var attrs [7]float64
+		for j := range attrs {
+			v, ok := dtInt(cells[i+1+j])
+			if !ok {
+				return nil, fmt.Errorf("key %d: invalid attribute %q", len(layout.Keys), cells[i+1+j])
+			}
+			attrs[j] = float64(v) / 100
+		}
+		layout.Keys = append(layout.Keys, PhysicalKey{
+			W:     attrs[0],
+			H:     attrs[1],
+			X:     attrs[2],
+			Y:     attrs[3],
+			R:     attrs[4],
+			RX:    attrs[5],
+			RY:    attrs[6],
+			Index: len(layout.Keys),
+		})
+		i += 8
+	}
+	if len(layout.Keys) == 0 {
+		return nil, fmt.Errorf("physical layout %q has no keys", zmkLayoutID(node))
+	}
+
+	if ref := dtCells(node.Props["transform"]); len(ref) > 0 {
+		transform := findDTLabel(tree, strings.TrimPrefix(ref[0], "&"))
+		if transform != nil {
+			for i, rc := range parseMatrixTransform(transform) {
+				if i < len(layout.Keys) {
+					layout.Keys[i].Matrix = rc
+				}
+			}
+		}
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,378 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// zmkKeymapCompatible marks the devicetree node holding a keymap's layer nodes
+const zmkKeymapCompatible = `"zmk,keymap"`
+
+// Properties of layer nodes
+var (
+	dtBindingsProp    = regexp.MustCompile(`(?:^|[\s;{])bindings\s*=\s*([^;]*);`)
+	dtDisplayNameProp = regexp.MustCompile(`(?:^|[\s;{])display-name\s*=\s*("[^"]*")`)
+	dtGroupSeparator  = regexp.MustCompile(`>\s*,\s*<`)
+)
+
+// layerSite is where a layer is defined in a ZMK keymap source, as byte
+// offsets into the source
+type layerSite struct {
+	Name          string // Identifier: the ZMK_LAYER argument or the node's name
+	DisplayName   string // A node's display-name, if any
+	Start         int    // Start of the definition: "ZMK_LAYER" or the node's label or name
+	BindingsStart int    // The binding list, without the angle brackets of a node's bindings
+	BindingsEnd   int
+}
+
+// keymapLayerSites finds the layers of a ZMK keymap source, in order. Layers
+// are the ZMK_LAYER macros when there are any, and otherwise the child nodes
+// of the zmk,keymap node. Comments and preprocessor directives are skipped.
+func keymapLayerSites(content string) []layerSite {
+	code := maskComments(content)
+	if sites := zmkLayerMacroSites(code); len(sites) > 0 {
+		return sites
+	}
+	return layerNodeSites(code)
+}
+
+// zmkLayerMacroSites finds the ZMK_LAYER(name, bindings) macros of masked source
+func zmkLayerMacroSites(code string) []layerSite {
+	const prefix = "ZMK_LAYER"
+	var sites []layerSite
+	idx := 0
+	for {
+		start := strings.Index(code[idx:], prefix)
+		if start == -1 {
+			break
+		}
+		start += idx
+		idx = start + len(prefix)
+		if start > 0 && isIdentByte(code[start-1]) {
+			continue
+		}
+
+		// The opening paren follows the macro name, possibly after spaces
+		parenStart := idx
+		for parenStart < len(code) && (code[parenStart] == ' ' || code[parenStart] == '\t') {
+			parenStart++
+		}
+		if parenStart >= len(code) || code[parenStart] != '(' {
+			continue
+		}
+		parenEnd := findMatchingParen(code, parenStart)
+		if parenEnd == -1 {
+			idx = parenStart + 1
+			continue
+		}
+		idx = parenEnd + 1
+
+		// The first comma separates the layer name from its bindings
+		commaIdx := strings.Index(code[parenStart:parenEnd], ",")
+		if commaIdx == -1 {
+			continue
+		}
+		commaIdx += parenStart
+		sites = append(sites, layerSite{
+			Name:          strings.TrimSpace(code[parenStart+1 : commaIdx]),
+			Start:         start,
+			BindingsStart: commaIdx + 1,
+			BindingsEnd:   parenEnd,
+		})
+	}
+	return sites
+}
+
+// layerNodeSites finds the layer nodes of masked source: the child nodes with
+// bindings of every node with compatible = "zmk,keymap"
+func layerNodeSites(code string) []layerSite {
+	var sites []layerSite
+	idx := 0
+	for {
+		at := strings.Index(code[idx:], zmkKeymapCompatible)
+		if at == -1 {
+			break
+		}
+		at += idx
+		idx = at + len(zmkKeymapCompatible)
+
+		open := enclosingBrace(code, at)
+		if open == -1 {
+			continue
+		}
+		end := findMatchingBrace(code, open)
+		if end == -1 {
+			continue
+		}
+		for i := open + 1; i < end; {
+			// A statement ends with ';' for a property or '{' for a child node
+			j := i
+			for j < end && code[j] != ';' && code[j] != '{' {
+				j++
+			}
+			if j >= end {
+				break
+			}
+			if code[j] == ';' {
+				i = j + 1
+				continue
+			}
+			close := findMatchingBrace(code, j)
+			if close == -1 || close > end {
+				break
+			}
+			statement := code[i:j]
+			start := i + len(statement) - len(strings.TrimLeft(statement, " \t\r\n"))
+			i = close + 1
+
+			body := code[j+1 : close]
+			m := dtBindingsProp.FindStringSubmatchIndex(body)
+			if m == nil {
+				continue
+			}
+			bindingsStart, bindingsEnd := j+1+m[2], j+1+m[3]
+			value := code[bindingsStart:bindingsEnd]
+			if open := strings.Index(value, "<"); open != -1 {
+				bindingsStart += open + 1
+			}
+			if close := strings.LastIndex(code[bindingsStart:bindingsEnd], ">"); close != -1 {
+				bindingsEnd = bindingsStart + close
+			}
+
+			site := layerSite{Name: strings.TrimSpace(statement), Start: start, BindingsStart: bindingsStart, BindingsEnd: bindingsEnd}
+			if _, name, ok := strings.Cut(site.Name, ":"); ok {
+				site.Name = strings.TrimSpace(name)
+			}
+			if m := dtDisplayNameProp.FindStringSubmatch(body); m != nil {
+				site.DisplayName = dtString(m[1])
+			}
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
+
+// siteKeys returns the labels of the bindings of a layer site
+func siteKeys(content string, site layerSite) []string {
+	bindings := content[site.BindingsStart:site.BindingsEnd]
+	bindings = dtGroupSeparator.ReplaceAllString(bindings, " ")
+	return parseKeysFlat(maskComments(bindings))
+}
+
+// enclosingBrace returns the index of the unclosed '{' before at, or -1
+func enclosingBrace(s string, at int) int {
+	depth := 0
+	for i := at - 1; i >= 0; i-- {
+		switch s[i] {
+		case '}':
+			depth++
+		case '{':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// isIdentByte reports whether c can be part of a C identifier
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// dtDirectives are the preprocessor directives maskComments blanks out;
+// devicetree properties such as #binding-cells also start with '#'
+var dtDirectives = regexp.MustCompile(`^#\s*(?:define|undef|include|if|ifdef|ifndef|elif|else|endif|pragma|error|warning|line)\b`)
+
+// maskComments blanks out the comments and preprocessor directives of source
+// with spaces, keeping line breaks and string literals, so offsets into the
+// result are offsets into the source
+func maskComments(src string) string {
+	b := []byte(src)
+	blank := func(from, to int) {
+		for k := from; k < to; k++ {
+			if b[k] != '\n' && b[k] != '\r' {
+				b[k] = ' '
+			}
+		}
+	}
+	inString := false
+	lineStart := true
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case inString:
+			if c == '\\' && i+1 < len(src) {
+				i++
+			} else if c == '"' || c == '\n' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := strings.IndexByte(src[i:], '\n')
+			if end == -1 {
+				end = len(src) - i
+			}
+			blank(i, i+end)
+			i += end - 1
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				blank(i, len(src))
+				return string(b)
+			}
+			blank(i, i+end+4)
+			i += end + 3
+		case c == '#' && lineStart && dtDirectives.MatchString(src[i:]):
+			// Directives run to the end of the line, honoring line continuations
+			end := i
+			for end < len(src) && !(src[end] == '\n' && src[end-1] != '\\') {
+				end++
+			}
+			blank(i, end)
+			i = end - 1
+		}
+
+		if i < len(src) && src[i] == '\n' {
+			lineStart = true
+		} else if c != ' ' && c != '\t' {
+			lineStart = false
+		}
+	}
+	return string(b)
+}
+
+// artCommentLead matches what comes before the text on a comment line: the
+// indentation, the comment marker or a block comment's leading '*', and a space
+var artCommentLead = regexp.MustCompile(`^[ \t]*(?://+|/\*+|\*)? ?`)
+
+// RewriteLayerArt puts art, lines of text, in a comment before each layer of a
+// ZMK keymap source; art[i] goes before layer i and nil leaves a layer alone.
+// When the comment directly above a layer, blank lines apart at most, holds
+// ASCII art, the lines of art are replaced and take the framing of the lines
+// they replace, so prose in the comment, its delimiters and its style stay;
+// otherwise the new art goes in line comments right above the layer. The rest
+// of the source is kept byte for byte.
+func RewriteLayerArt(content string, art [][]string) string {
+	lines := strings.SplitAfter(content, "\n")
+	newline := "\n"
+	if strings.Contains(content, "\r\n") {
+		newline = "\r\n"
+	}
+	lineOf := func(offset int) int {
+		return strings.Count(content[:offset], "\n")
+	}
+
+	type edit struct {
+		from, to int // Lines replaced
+		lines    []string
+	}
+	var edits []edit
+	lastLine := -1
+	for i, site := range keymapLayerSites(content) {
+		if i >= len(art) || art[i] == nil {
+			continue
+		}
+		at := lineOf(site.Start)
+		if at == lastLine {
+			continue
+		}
+		lastLine = at
+
+		// Without art above the layer, new line comments go right above it
+		e := edit{from: at, to: at}
+		line := lines[at]
+		first := line[:len(line)-len(strings.TrimLeft(line, " \t"))] + "// "
+		rest, tail := first, ""
+		if from, to := commentAbove(lines, at); from < to {
+			block := make([][]rune, to-from)
+			for l := from; l < to; l++ {
+				block[l-from] = []rune(lines[l])
+			}
+			if len(artCells(block)) > 0 {
+				// Only the art lines are replaced; prose around them and the
+				// comment's own framing stay
+				for from < to && !isArtLine(block[0]) {
+					from++
+					block = block[1:]
+				}
+				for to > from && !isArtLine(block[len(block)-1]) {
+					to--
+					block = block[:len(block)-1]
+				}
+				e.from, e.to = from, to
+				first = artCommentLead.FindString(lines[from])
+				rest = strings.Replace(first, "/*", " *", 1)
+				if to-from > 1 {
+					rest = artCommentLead.FindString(lines[from+1])
+				}
+				last := strings.TrimRight(lines[to-1], "\r\n")
+				if end := strings.LastIndex(last, "*/"); end != -1 {
+					tail = " " + last[end:]
+				}
+			}
+		}
+		for n, text := range art[i] {
+			lead := rest
+			if n == 0 {
+				lead = first
+			}
+			text = strings.TrimRight(lead+text, " ")
+			if n == len(art[i])-1 {
+				text += tail
+			}
+			e.lines = append(e.lines, text+newline)
+		}
+		edits = append(edits, e)
+	}
+
+	for n := len(edits) - 1; n >= 0; n-- {
+		e := edits[n]
+		lines = append(lines[:e.from], append(e.lines, lines[e.to:]...)...)
+	}
+	return strings.Join(lines, "")
+}
+
+// isArtLine reports whether a comment line can be part of a diagram: a border
+// line, or one with at least two cell separators
+func isArtLine(line []rune) bool {
+	separators := 0
+	for _, r := range line {
+		if isArtSeparator(r) {
+			separators++
+		}
+	}
+	return separators >= 2 || isArtBorderLine(line)
+}
+
+// commentAbove returns the lines [from, to) of the comment right above line
+// at, skipping blank lines in between; from == to when there is none. Only
+// whole-line comments count.
+func commentAbove(lines []string, at int) (from, to int) {
+	l := at - 1
+	for l >= 0 && strings.TrimSpace(lines[l]) == "" {
+		l--
+	}
+	to = l + 1
+	for l >= 0 {
+		trimmed := strings.TrimSpace(lines[l])
+		switch {
+		case strings.HasPrefix(trimmed, "//"):
+			l--
+		case strings.HasSuffix(trimmed, "*/"):
+			open := l
+			for open >= 0 && !strings.Contains(lines[open], "/*") {
+				open--
+			}
+			if open < 0 || !strings.HasPrefix(strings.TrimSpace(lines[open]), "/*") {
+				return l + 1, to
+			}
+			l = open - 1
+		default:
+			return l + 1, to
+		}
+	}
+	return 0, to
+}
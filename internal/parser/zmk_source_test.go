@@ -0,0 +1,108 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// artSource has art above its layers in a block comment with prose, in line
+// comments with prose, and no comment at all
+const artSource = `#include <behaviors.dtsi>
+
+/ {
+    keymap {
+        compatible = "zmk,keymap";
+
+        /* Base layer
+         * +---+---+
+         * | A | B |
+         * +---+---+
+         * Hold B for the lower layer */
+        base {
+            bindings = <&kp A &mo 1>;
+        };
+
+        // Lower layer
+        // +---+---+
+        // | 1 | 2 |
+        // +---+---+
+        lower {
+            bindings = <&kp N1 &kp N2>; // Numbers
+        };
+
+        raise {
+            bindings = <&kp F1 &kp F2>;
+        };
+    };
+};
+`
+
+// artSourceRewritten is artSource with new art: only the lines of art differ
+const artSourceRewritten = `#include <behaviors.dtsi>
+
+/ {
+    keymap {
+        compatible = "zmk,keymap";
+
+        /* Base layer
+         * +-----+-----+
+         * |  A  |  L  |
+         * +-----+-----+
+         * Hold B for the lower layer */
+        base {
+            bindings = <&kp A &mo 1>;
+        };
+
+        // Lower layer
+        // +-----+-----+
+        // |  1  |  2  |
+        // +-----+-----+
+        lower {
+            bindings = <&kp N1 &kp N2>; // Numbers
+        };
+
+        // +-----+-----+
+        // |  F1 |  F2 |
+        // +-----+-----+
+        raise {
+            bindings = <&kp F1 &kp F2>;
+        };
+    };
+};
+`
+
+var testArt = [][]string{
+	{"+-----+-----+", "|  A  |  L  |", "+-----+-----+"},
+	{"+-----+-----+", "|  1  |  2  |", "+-----+-----+"},
+	{"+-----+-----+", "|  F1 |  F2 |", "+-----+-----+"},
+}
+
+func TestRewriteLayerArt(t *testing.T) {
+	got := RewriteLayerArt(artSource, testArt)
+	if got != artSourceRewritten {
+		t.Errorf("rewritten source:\n%s\nwant:\n%s", got, artSourceRewritten)
+	}
+	if again := RewriteLayerArt(got, testArt); again != got {
+		t.Errorf("rewriting again changed the source:\n%s", again)
+	}
+}
+
+func TestRewriteLayerArtCRLF(t *testing.T) {
+	crlf := func(s string) string { return strings.ReplaceAll(s, "\n", "\r\n") }
+	got := RewriteLayerArt(crlf(artSource), testArt)
+	if want := crlf(artSourceRewritten); got != want {
+		t.Errorf("rewritten source:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+// TestRewriteLayerArtBlockFraming checks art that shares its lines with the
+// delimiters of a block comment
+func TestRewriteLayerArtBlockFraming(t *testing.T) {
+	source := "ZMK_LAYER(base,\n    &kp A &kp B)\n"
+	commented := "/* +---+---+\n * | A | B |\n * +---+---+ */\n" + source
+	art := [][]string{{"+--+--+", "|A |B |", "+--+--+"}}
+	want := "/* +--+--+\n * |A |B |\n * +--+--+ */\n" + source
+	if got := RewriteLayerArt(commented, art); got != want {
+		t.Errorf("rewritten source:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"fmt"
+	"strconv"
+
+	"keyviewer/internal/parser"
+)
+
+// MaxCompactLayers is how many layers a compact scene shows: one in the middle
+// of the keys and one in each corner
+const MaxCompactLayers = 5
+
+// Legend colors of the layers of a compact scene, the first for the middle
+var layerColors = []string{"#cccccc", "#ffa060", "#60c8ff", "#a0e060", "#ff70b0"}
+
+// layerColor returns the legend color of the i-th layer of a compact scene
+func layerColor(i int) string {
+	return layerColors[i%len(layerColors)]
+}
+
+// LayerScenes lays out the given layers of a keymap one scene each, for
+// drawing them stacked. No layers means all of them.
+func LayerScenes(keymap *parser.Keymap, layout *parser.Layout, layers []int) ([]*Scene, error) {
+	if len(layers) == 0 {
+		for i := range keymap.Layers {
+			layers = append(layers, i)
+		}
+	}
+	scenes := make([]*Scene, 0, len(layers))
+	for _, i := range layers {
+		scene, err := LayerScene(keymap, layout, i)
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, scene)
+	}
+	return scenes, nil
+}
+
+// CompactScene lays out several layers of a keymap in one scene: the first
+// layer in the middle of the keys and the others in their corners, colored
+// per layer. Transparent and empty bindings are left out of the corners. No
+// layers means the first MaxCompactLayers.
+func CompactScene(keymap *parser.Keymap, layout *parser.Layout, layers []int) (*Scene, error) {
+	if len(layers) == 0 {
+		for i := 0; i < len(keymap.Layers) && i < MaxCompactLayers; i++ {
+			layers = append(layers, i)
+		}
+	}
+	if len(layers) > MaxCompactLayers {
+		return nil, fmt.Errorf("compact overview shows at most %d layers", MaxCompactLayers)
+	}
+	for _, i := range layers {
+		if i < 0 || i >= len(keymap.Layers) {
+			return nil, fmt.Errorf("layer %d out of range", i)
+		}
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("keymap has no layers")
+	}
+
+	scene, err := LayerScene(keymap, layout, layers[0])
+	if err != nil {
+		return nil, err
+	}
+	scene.Title = keymap.Name
+	for n, i := range layers {
+		scene.Layers = append(scene.Layers, SceneLayer{Name: keymap.Layers[i].Name, Color: layerColor(n)})
+	}
+
+	for k := range scene.Keys {
+		key := &scene.Keys[k]
+		// The corners take the places of the hold and shifted legends
+		key.Hold, key.Shifted = "", ""
+		if key.Binding < 0 {
+			continue
+		}
+		for corner, i := range layers[1:] {
+			layer := keymap.Layers[i]
+			if key.Binding >= len(layer.Keys) {
+				continue
+			}
+			label := layer.Keys[key.Binding]
+			if label == "" || label == "▽" {
+				continue
+			}
+			if custom := layer.CustomNames[strconv.Itoa(key.Binding)]; custom != "" {
+				label = custom
+			}
+			key.Corners[corner] = label
+		}
+	}
+	return scene, nil
+}
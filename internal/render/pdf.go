@@ -0,0 +1,358 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"keyviewer/internal/parser"
+)
+
+// MaxLayersPerPage is how many layers a cheat sheet page holds at most
+const MaxLayersPerPage = 4
+
+// Cheat sheet page layout, in points
+const (
+	pdfMargin      = 36.0
+	pdfFooter      = 18.0 // Space for the page number under the content
+	pdfTitleBlock  = 56.0 // Space for the title block on the first page
+	pdfTitleSize   = 20.0
+	pdfHeadingSize = 14.0
+	pdfTextSize    = 10.0
+	pdfLineHeight  = 14.0
+	pdfCellPadding = 8.0 // Space between table columns
+)
+
+// Paper sizes in points, portrait
+var paperSizes = map[string][2]float64{
+	"a4":     {595.28, 841.89},
+	"letter": {612, 792},
+}
+
+// PDFOptions selects what a cheat sheet prints and on what paper
+type PDFOptions struct {
+	Paper   string // "a4" (default) or "letter"
+	PerPage int    // Layers per page, 1 (default) to MaxLayersPerPage
+	Layers  []int  // Layers to print, all if empty
+}
+
+// PDF prints a keymap as a cheat sheet: a title block, its layers drawn on
+// the layout (indices bound as for LayerScene) and an appendix of combos,
+// macros and leader sequences. Pages are landscape with one layer per page
+// and portrait with several.
+func PDF(keymap *parser.Keymap, layout *parser.Layout, opts PDFOptions) ([]byte, error) {
+	paper := strings.ToLower(opts.Paper)
+	if paper == "" {
+		paper = "a4"
+	}
+	size, ok := paperSizes[paper]
+	if !ok {
+		return nil, fmt.Errorf("unknown paper size %q", opts.Paper)
+	}
+	perPage := opts.PerPage
+	if perPage == 0 {
+		perPage = 1
+	}
+	if perPage < 1 || perPage > MaxLayersPerPage {
+		return nil, fmt.Errorf("layers per page must be 1 to %d", MaxLayersPerPage)
+	}
+	layers := opts.Layers
+	if len(layers) == 0 {
+		for i := range keymap.Layers {
+			layers = append(layers, i)
+		}
+	}
+
+	scenes, err := LayerScenes(keymap, layout, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &pdfDoc{title: keymap.Name, width: size[0], height: size[1]}
+	if perPage == 1 {
+		doc.width, doc.height = doc.height, doc.width
+	}
+	page := doc.newPage()
+	writeTitleBlock(page, keymap, layout, len(scenes))
+
+	// Every layer gets a slot of the same size, and all are drawn at the
+	// scale of the one that needs the most shrinking
+	contentWidth := doc.width - 2*pdfMargin
+	slotHeight := (doc.height - 2*pdfMargin - pdfFooter - pdfTitleBlock) / float64(perPage)
+	scale := math.Inf(1)
+	for i, scene := range scenes {
+		scene.Title = keymap.Layers[layers[i]].Name
+		w, h, _ := canvasSize(scene)
+		scale = math.Min(scale, math.Min(contentWidth/w, slotHeight/h))
+	}
+
+	top := pdfMargin + pdfTitleBlock
+	for i, scene := range scenes {
+		if i > 0 && i%perPage == 0 {
+			page = doc.newPage()
+			top = pdfMargin
+		}
+		w, _, _ := canvasSize(scene)
+		pdfScene(page, scene, affine{scale, 0, 0, scale, (doc.width - w*scale) / 2, top})
+		top += slotHeight
+	}
+
+	if len(visibleCombos(keymap)) > 0 || len(keymap.Macros) > 0 {
+		flow := &pdfFlow{doc: doc, page: doc.newPage(), y: pdfMargin}
+		writeCombos(flow, keymap)
+		writeMacros(flow, keymap)
+	}
+
+	for i, p := range doc.pages {
+		footer := fmt.Sprintf("%s — page %d of %d", keymap.Name, i+1, len(doc.pages))
+		p.text(fontRegular, footer, doc.width/2, doc.height-pdfMargin/2, 8, 0, printTheme.Legend)
+	}
+	return doc.bytes()
+}
+
+// writeTitleBlock writes the keymap's name and what the sheet holds
+func writeTitleBlock(page *pdfPage, keymap *parser.Keymap, layout *parser.Layout, layers int) {
+	page.text(fontBold, keymap.Name, pdfMargin, pdfMargin+pdfTitleSize, pdfTitleSize, -1, printTheme.Title)
+
+	var facts []string
+	if layout.Name != "" {
+		facts = append(facts, "Layout: "+layout.Name)
+	}
+	facts = append(facts, plural(layers, "layer"))
+	if combos := visibleCombos(keymap); len(combos) > 0 {
+		facts = append(facts, plural(len(combos), "combo"))
+	}
+	if len(keymap.Macros) > 0 {
+		facts = append(facts, plural(len(keymap.Macros), "macro"))
+	}
+	page.text(fontRegular, strings.Join(facts, " · "), pdfMargin, pdfMargin+pdfTitleSize+18, pdfTextSize, -1, printTheme.Legend)
+}
+
+// plural returns "1 thing" or "n things"
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return strconv.Itoa(n) + " " + thing + "s"
+}
+
+// pdfScene paints a scene in the print theme; m places the scene's canvas
+func pdfScene(page *pdfPage, scene *Scene, m affine) {
+	t := printTheme
+	page.save()
+	page.transform(m)
+	if scene.Title != "" {
+		page.text(fontBold, scene.Title, Margin, Margin+titleSize, titleSize, -1, t.Title)
+	}
+	_, _, offset := canvasSize(scene)
+	page.transform(translation(offset.X, offset.Y))
+	for _, key := range scene.Keys {
+		pdfKey(page, key.withLabels(pdfLabel), t)
+	}
+	for _, combo := range scene.Combos {
+		pdfCombo(page, scene, combo.withLabels(pdfLabel), t)
+	}
+	page.restore()
+}
+
+// pdfKey paints a key with its legends, rotated into place
+func pdfKey(page *pdfPage, key Key, t *theme) {
+	k := key.Physical
+	if k.Decal {
+		return
+	}
+	page.save()
+	if k.R != 0 {
+		page.transform(rotation(k.R, k.RX*KeySize, k.RY*KeySize))
+	}
+	if key.Faded {
+		page.fade()
+	}
+
+	s := t.styleOf(key)
+	stroke, strokeWidth := t.keyStroke(key)
+	var dash []float64
+	if s.Dashed {
+		dash = []float64{3, 2}
+	}
+	page.strokeColor(stroke)
+	page.lineStyle(strokeWidth, dash)
+	outline := roundCorners(keyOutline(k), cornerRound)
+	if s.Fill != "" {
+		page.fillColor(s.Fill)
+		page.polygon(outline, "B")
+	} else {
+		page.polygon(outline, "S")
+	}
+	if key.Custom {
+		r := keyRect(k)
+		page.fillColor(t.CustomDot)
+		page.polygon(circle(r.X+r.W-5, r.Y+5, 2.5), "f")
+	}
+	for _, spot := range t.keyLegends(key) {
+		pdfLegend(page, spot)
+	}
+	page.restore()
+}
+
+// pdfCombo paints a combo's box with dashed lines to the keys that trigger it
+func pdfCombo(page *pdfPage, scene *Scene, combo Combo, t *theme) {
+	center := parser.Point{X: combo.Center.X * KeySize, Y: combo.Center.Y * KeySize}
+	page.strokeColor(t.ComboLine)
+	page.lineStyle(1, []float64{2, 2})
+	for _, pos := range combo.Keys {
+		kc := scene.Keys[pos].Physical.Center()
+		page.line(center, parser.Point{X: kc.X * KeySize, Y: kc.Y * KeySize})
+	}
+	w, h := comboWidth*KeySize, comboHeight*KeySize
+	page.lineStyle(1, nil)
+	page.fillColor(t.ComboFill)
+	page.polygon(roundCorners(rectPoints(center.X-w/2, center.Y-h/2, w, h), 3), "B")
+	for _, spot := range t.comboLegends(combo) {
+		pdfLegend(page, spot)
+	}
+}
+
+// pdfLegend writes the lines of a legend the way writeLegend places them
+func pdfLegend(page *pdfPage, spot legendSpot) {
+	for i, line := range spot.Lines {
+		y := spot.Y + (float64(i)-float64(len(spot.Lines)-1)/2)*spot.Size*lineHeight
+		page.text(fontRegular, line, spot.X, y+spot.Size*0.35, spot.Size, spot.Align, spot.Color)
+	}
+}
+
+// pdfFlow writes text down the pages, starting a new page when one is full
+type pdfFlow struct {
+	doc  *pdfDoc
+	page *pdfPage
+	y    float64 // Top of the free space on the page
+}
+
+// need starts a new page unless height points are left on this one
+func (f *pdfFlow) need(height float64) {
+	if f.y+height > f.doc.height-pdfMargin-pdfFooter {
+		f.page = f.doc.newPage()
+		f.y = pdfMargin
+	}
+}
+
+// heading writes a section heading, kept on the page with the line after it
+func (f *pdfFlow) heading(text string) {
+	if f.y > pdfMargin {
+		f.y += pdfLineHeight
+	}
+	f.need(pdfHeadingSize + 6 + 2*pdfLineHeight)
+	f.page.text(fontBold, text, pdfMargin, f.y+pdfHeadingSize, pdfHeadingSize, -1, printTheme.Title)
+	f.y += pdfHeadingSize + 6
+}
+
+// row writes a table row, wrapping each cell within its share of the page
+// width; shares add up to 1
+func (f *pdfFlow) row(font string, cells []string, shares []float64) {
+	width := f.doc.width - 2*pdfMargin
+	wrapped := make([][]string, len(cells))
+	lines := 1
+	for i, cell := range cells {
+		wrapped[i] = wrapPDFText(cell, pdfTextSize, width*shares[i]-pdfCellPadding)
+		lines = max(lines, len(wrapped[i]))
+	}
+	f.need(float64(lines) * pdfLineHeight)
+
+	x := pdfMargin
+	for i, cellLines := range wrapped {
+		for j, line := range cellLines {
+			f.page.text(font, line, x, f.y+float64(j)*pdfLineHeight+pdfTextSize, pdfTextSize, -1, printTheme.Title)
+		}
+		x += width * shares[i]
+	}
+	f.y += float64(lines) * pdfLineHeight
+}
+
+// wrapPDFText breaks text into lines no wider than width at size points,
+// breaking at spaces where it can
+func wrapPDFText(text string, size, width float64) []string {
+	fits := func(s string) bool { return pdfTextWidth(winAnsi(s), size) <= width }
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && fits(line+" "+word) {
+			line += " " + word
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		// Words wider than a line are broken anywhere
+		line = ""
+		for _, r := range word {
+			if line != "" && !fits(line+string(r)) {
+				lines = append(lines, line)
+				line = ""
+			}
+			line += string(r)
+		}
+	}
+	if line != "" || len(lines) == 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// writeCombos writes the table of combos: the keys pressed, named after the
+// first layer's legends, what they do and where
+func writeCombos(f *pdfFlow, keymap *parser.Keymap) {
+	combos := visibleCombos(keymap)
+	if len(combos) == 0 {
+		return
+	}
+	shares := []float64{0.35, 0.35, 0.3}
+	f.heading("Combos")
+	f.row(fontBold, []string{"Keys", "Output", "Layers"}, shares)
+	for _, combo := range combos {
+		keys := make([]string, len(combo.Positions))
+		for i, pos := range combo.Positions {
+			keys[i] = positionLabel(keymap, pos)
+		}
+		output := combo.Label
+		if combo.Hold != "" {
+			output += " (hold: " + combo.Hold + ")"
+		}
+		layers := "All"
+		if len(combo.Layers) > 0 {
+			layers = strings.Join(combo.Layers, ", ")
+		}
+		f.row(fontRegular, []string{strings.Join(keys, " + "), output, layers}, shares)
+	}
+}
+
+// positionLabel names a key position by its legend on the first layer
+func positionLabel(keymap *parser.Keymap, pos int) string {
+	if len(keymap.Layers) > 0 && pos >= 0 && pos < len(keymap.Layers[0].Keys) {
+		layer := keymap.Layers[0]
+		if custom := layer.CustomNames[strconv.Itoa(pos)]; custom != "" {
+			return custom
+		}
+		if label := layer.Keys[pos]; label != "" && label != "▽" {
+			return label
+		}
+	}
+	return "#" + strconv.Itoa(pos)
+}
+
+// writeMacros writes the appendix of macros and leader sequences
+func writeMacros(f *pdfFlow, keymap *parser.Keymap) {
+	if len(keymap.Macros) == 0 {
+		return
+	}
+	shares := []float64{0.25, 0.25, 0.5}
+	f.heading("Macros and leader sequences")
+	f.row(fontBold, []string{"Name", "Trigger", "Bindings"}, shares)
+	for _, macro := range keymap.Macros {
+		trigger := ""
+		if len(macro.Sequence) > 0 {
+			trigger = "Leader, " + strings.Join(macro.Sequence, " ")
+		}
+		f.row(fontRegular, []string{macro.Name, trigger, strings.Join(macro.Bindings, " ")}, shares)
+	}
+}
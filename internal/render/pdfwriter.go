@@ -0,0 +1,259 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+
+	"keyviewer/internal/parser"
+)
+
+// The standard PDF fonts the cheat sheet is set in; viewers always have them,
+// so nothing needs embedding
+const (
+	fontRegular = "F1" // Helvetica
+	fontBold    = "F2" // Helvetica-Bold
+)
+
+// helveticaWidths are the advance widths of Helvetica for ASCII 32-126, in
+// 1/1000 em, from the font's standard metrics
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// winAnsiSpecials are the characters of WinAnsiEncoding outside ASCII and
+// Latin-1, with their codes
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfFallbacks stand in for label symbols WinAnsiEncoding has no code for,
+// before glyphFallbacks are tried
+var pdfFallbacks = map[rune]string{
+	'▽': "trans",
+	'▶': ">",
+	'▼': "v",
+	'►': ">",
+	'←': "Left",
+	'→': "Right",
+	'↑': "Up",
+	'↓': "Down",
+}
+
+// pdfLabel replaces the characters of a label the standard fonts cannot show
+// with their fallbacks, or "?"
+func pdfLabel(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 32 && r < 127, r >= 0xA0 && r <= 0xFF, winAnsiSpecials[r] != 0:
+			b.WriteRune(r)
+		case pdfFallbacks[r] != "":
+			b.WriteString(pdfFallbacks[r])
+		case glyphFallbacks[r] != "":
+			b.WriteString(pdfLabel(glyphFallbacks[r]))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// winAnsi encodes text for the standard fonts
+func winAnsi(s string) []byte {
+	var b []byte
+	for _, r := range pdfLabel(s) {
+		if code := winAnsiSpecials[r]; code != 0 {
+			b = append(b, code)
+		} else {
+			b = append(b, byte(r))
+		}
+	}
+	return b
+}
+
+// pdfTextWidth returns the width of WinAnsi text in Helvetica at size points
+func pdfTextWidth(text []byte, size float64) float64 {
+	width := 0
+	for _, c := range text {
+		if c >= 32 && c < 127 {
+			width += helveticaWidths[c-32]
+		} else {
+			width += 556
+		}
+	}
+	return float64(width) * size / 1000
+}
+
+// pdfPage is the content stream of a page. Drawing is in points from the
+// top-left corner of the page, y down, like the other renderers.
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+// op writes an operator line
+func (p *pdfPage) op(format string, args ...any) {
+	fmt.Fprintf(&p.content, format+"\n", args...)
+}
+
+// save and restore push and pop the graphics state
+func (p *pdfPage) save()    { p.op("q") }
+func (p *pdfPage) restore() { p.op("Q") }
+
+// transform concatenates m to the current transform
+func (p *pdfPage) transform(m affine) {
+	p.op("%s %s %s %s %s %s cm", num(m[0]), num(m[1]), num(m[2]), num(m[3]), num(m[4]), num(m[5]))
+}
+
+// fade makes everything drawn until the next restore translucent
+func (p *pdfPage) fade() {
+	p.op("/Faded gs")
+}
+
+// fillColor and strokeColor set the paint of later drawing
+func (p *pdfPage) fillColor(hex string) {
+	c := parseColor(hex, 1)
+	p.op("%s %s %s rg", num(float64(c.R)/255), num(float64(c.G)/255), num(float64(c.B)/255))
+}
+
+func (p *pdfPage) strokeColor(hex string) {
+	c := parseColor(hex, 1)
+	p.op("%s %s %s RG", num(float64(c.R)/255), num(float64(c.G)/255), num(float64(c.B)/255))
+}
+
+// lineStyle sets the width of strokes and their dash pattern, none if empty
+func (p *pdfPage) lineStyle(width float64, dash []float64) {
+	parts := make([]string, len(dash))
+	for i, d := range dash {
+		parts[i] = num(d)
+	}
+	p.op("%s w [%s] 0 d", num(width), strings.Join(parts, " "))
+}
+
+// polygon paints a closed outline: "f" fills, "S" strokes, "B" does both
+func (p *pdfPage) polygon(points []parser.Point, paint string) {
+	for i, pt := range points {
+		if i == 0 {
+			p.op("%s %s m", num(pt.X), num(pt.Y))
+		} else {
+			p.op("%s %s l", num(pt.X), num(pt.Y))
+		}
+	}
+	p.op("h %s", paint)
+}
+
+// line strokes a straight line
+func (p *pdfPage) line(a, b parser.Point) {
+	p.op("%s %s m %s %s l S", num(a.X), num(a.Y), num(b.X), num(b.Y))
+}
+
+// text writes a line of text with its baseline at y; align places x at the
+// start (-1), middle (0) or end (1) of the line
+func (p *pdfPage) text(font string, s string, x, y, size float64, align int, color string) {
+	encoded := winAnsi(s)
+	switch align {
+	case 0:
+		x -= pdfTextWidth(encoded, size) / 2
+	case 1:
+		x -= pdfTextWidth(encoded, size)
+	}
+	p.fillColor(color)
+	// The page is flipped to y down, so the text is flipped back upright
+	p.op("BT /%s %s Tf 1 0 0 -1 %s %s Tm %s Tj ET", font, num(size), num(x), num(y), pdfString(encoded))
+}
+
+// pdfString writes encoded text as a PDF string literal
+func pdfString(text []byte) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, c := range text {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 32 || c > 126:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// pdfDoc is a PDF document of same-sized pages
+type pdfDoc struct {
+	title         string
+	width, height float64 // Page size in points
+	pages         []*pdfPage
+}
+
+// newPage adds a blank page
+func (d *pdfDoc) newPage() *pdfPage {
+	page := &pdfPage{}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// bytes serializes the document. The output has no timestamps or IDs, so the
+// same document always gives the same bytes.
+func (d *pdfDoc) bytes() ([]byte, error) {
+	var b bytes.Buffer
+	var offsets []int
+	// Objects are numbered from 1 in the order they are written
+	object := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// 1 catalog, 2 page tree, 3 info, 4-5 fonts, 6 graphics state, then a
+	// page and its content stream for each page
+	const firstPage = 7
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title %s /Producer (keyviewer) >>", pdfString(winAnsi(d.title))))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object(fmt.Sprintf("<< /Type /ExtGState /ca %s /CA %s >>", num(fadedOpacity), num(fadedOpacity)))
+
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R "+
+			"/Resources << /Font << /%s 4 0 R /%s 5 0 R >> /ExtGState << /Faded 6 0 R >> >> >>",
+			num(d.width), num(d.height), firstPage+2*i+1, fontRegular, fontBold))
+
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "1 0 0 -1 0 %s cm\n", num(d.height))
+		content.Write(page.content.Bytes())
+		var stream bytes.Buffer
+		z, err := zlib.NewWriterLevel(&stream, zlib.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		z.Write(content.Bytes())
+		if err := z.Close(); err != nil {
+			return nil, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return b.Bytes(), nil
+}
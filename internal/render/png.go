@@ -0,0 +1,150 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"math"
+
+	"keyviewer/internal/parser"
+)
+
+// MaxScale limits the size of rasterized pictures
+const MaxScale = 4.0
+
+// PNG rasterizes scenes, stacked like StackedSVG, at scale times their size
+// in pixels. The geometry and legends are the same as in the SVG; legends use
+// the bundled Go font, so the output does not depend on the machine.
+func PNG(scenes []*Scene, scale float64) ([]byte, error) {
+	if scale <= 0 || scale > MaxScale {
+		return nil, fmt.Errorf("scale must be above 0 and at most %g", MaxScale)
+	}
+	width, height := 0.0, 0.0
+	for _, scene := range scenes {
+		w, h, _ := canvasSize(scene)
+		width = math.Max(width, w)
+		height += h
+	}
+
+	c, err := newCanvas(int(math.Ceil(width*scale)), int(math.Ceil(height*scale)), darkTheme.Background)
+	if err != nil {
+		return nil, err
+	}
+	top := 0.0
+	for _, scene := range scenes {
+		paintScene(c, scene, translation(0, top).then(scaling(scale)))
+		_, h, _ := canvasSize(scene)
+		top += h
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, c.img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// paintScene paints a scene with its title; m places the scene's canvas
+func paintScene(c *canvas, scene *Scene, m affine) {
+	_, _, offset := canvasSize(scene)
+	if scene.Title != "" {
+		c.text(m, c.printable(scene.Title), Margin, Margin+titleSize, titleSize, -1, parseColor(darkTheme.Title, 1))
+	}
+	for _, item := range colorKey(scene) {
+		y := Margin + titleHeight
+		swatch := roundCorners(rectPoints(item.X, y, keyRowSize/2, keyRowSize/2), 2)
+		c.fill([][]parser.Point{m.applyAll(swatch)}, parseColor(item.Color, 1))
+		c.text(m, c.printable(item.Name), item.X+keyRowSize/2+4, y+keyRowSize/4+legendSize*0.35, legendSize, -1, parseColor(item.Color, 1))
+	}
+
+	keys := translation(offset.X, offset.Y).then(m)
+	for _, key := range scene.Keys {
+		paintKey(c, key, keys)
+	}
+	for _, combo := range scene.Combos {
+		paintCombo(c, scene, combo, keys)
+	}
+}
+
+// paintKey paints a key with its legends, rotated into place
+func paintKey(c *canvas, key Key, m affine) {
+	k := key.Physical
+	if k.Decal {
+		return
+	}
+	key = key.withLabels(c.printable)
+	if k.R != 0 {
+		m = rotation(k.R, k.RX*KeySize, k.RY*KeySize).then(m)
+	}
+	opacity := 1.0
+	if key.Faded {
+		opacity = fadedOpacity
+	}
+
+	s := darkTheme.styleOf(key)
+	stroke, strokeWidth := darkTheme.keyStroke(key)
+	var dash []float64
+	if s.Dashed {
+		dash = []float64{3, 2}
+	}
+
+	outline := m.applyAll(roundCorners(keyOutline(k), cornerRound))
+	if s.Fill != "" {
+		c.fill([][]parser.Point{outline}, parseColor(s.Fill, opacity))
+	}
+	c.stroke(outline, true, strokeWidth*m.scale(), scaleDash(dash, m.scale()), parseColor(stroke, opacity))
+	if key.Custom {
+		r := keyRect(k)
+		c.fill([][]parser.Point{m.applyAll(circle(r.X+r.W-5, r.Y+5, 2.5))}, parseColor(darkTheme.CustomDot, opacity))
+	}
+	for _, spot := range darkTheme.keyLegends(key) {
+		paintLegend(c, spot, m, opacity)
+	}
+}
+
+// paintCombo paints a combo's box with dashed lines to the keys that trigger it
+func paintCombo(c *canvas, scene *Scene, combo Combo, m affine) {
+	center := parser.Point{X: combo.Center.X * KeySize, Y: combo.Center.Y * KeySize}
+	lineColor := parseColor(darkTheme.ComboLine, 1)
+	for _, pos := range combo.Keys {
+		kc := scene.Keys[pos].Physical.Center()
+		line := m.applyAll([]parser.Point{center, {X: kc.X * KeySize, Y: kc.Y * KeySize}})
+		c.stroke(line, false, m.scale(), scaleDash([]float64{2, 2}, m.scale()), lineColor)
+	}
+	w, h := comboWidth*KeySize, comboHeight*KeySize
+	box := m.applyAll(roundCorners(rectPoints(center.X-w/2, center.Y-h/2, w, h), 3))
+	c.fill([][]parser.Point{box}, parseColor(darkTheme.ComboFill, 1))
+	c.stroke(box, true, m.scale(), nil, lineColor)
+	combo = combo.withLabels(c.printable)
+	for _, spot := range darkTheme.comboLegends(combo) {
+		paintLegend(c, spot, m, 1)
+	}
+}
+
+// paintLegend paints the lines of a legend the way writeLegend places them
+func paintLegend(c *canvas, spot legendSpot, m affine, opacity float64) {
+	col := parseColor(spot.Color, opacity)
+	for i, line := range spot.Lines {
+		y := spot.Y + (float64(i)-float64(len(spot.Lines)-1)/2)*spot.Size*lineHeight
+		c.text(m, line, spot.X, y+spot.Size*0.35, spot.Size, spot.Align, col)
+	}
+}
+
+// rectPoints returns the corners of a rectangle, clockwise from the top-left
+func rectPoints(x, y, w, h float64) []parser.Point {
+	return []parser.Point{{X: x, Y: y}, {X: x + w, Y: y}, {X: x + w, Y: y + h}, {X: x, Y: y + h}}
+}
+
+// scale returns how much m enlarges lengths
+func (m affine) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// scaleDash scales a dash pattern
+func scaleDash(dash []float64, s float64) []float64 {
+	scaled := make([]float64, len(dash))
+	for i, d := range dash {
+		scaled[i] = d * s
+	}
+	return scaled
+}
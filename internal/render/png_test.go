@@ -0,0 +1,67 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"keyviewer/internal/parser"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestPNG rasterizes the base layer of the example keymap on a bundled layout
+// and compares the bytes with the golden PNGs in testdata
+func TestPNG(t *testing.T) {
+	data, err := os.ReadFile("../../layouts/kinesis-advantage2.kle.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layout parser.Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.ReadFile("../../input/example.keymap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keymap, err := parser.ParseKeymap(string(source), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bound := parser.BindLayout(&layout, parser.JoinPositions(&layout, keymap))
+	scene, err := LayerScene(keymap, bound, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, scale := range []float64{1, 2} {
+		t.Run(fmt.Sprintf("scale%g", scale), func(t *testing.T) {
+			got, err := PNG([]*Scene{scene}, scale)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", fmt.Sprintf("kinesis-advantage2-example@%gx.png", scale))
+			if *update {
+				if err := os.MkdirAll("testdata", 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("PNG at scale %g differs from %s (run go test -update if the change is intended)", scale, golden)
+			}
+		})
+	}
+}
@@ -0,0 +1,378 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+
+	"keyviewer/internal/parser"
+)
+
+// curveSteps is how many line segments approximate a curve or rounded corner
+const curveSteps = 6
+
+// The bundled Go Regular font, so rasterized legends look the same everywhere
+var (
+	fontOnce sync.Once
+	goFont   *sfnt.Font
+	fontErr  error
+)
+
+// loadFont parses the bundled font once
+func loadFont() (*sfnt.Font, error) {
+	fontOnce.Do(func() {
+		goFont, fontErr = sfnt.Parse(goregular.TTF)
+	})
+	return goFont, fontErr
+}
+
+// glyphFallbacks stand in for label symbols the bundled font has no glyphs for
+var glyphFallbacks = map[rune]string{
+	'▽': "▼",
+	'▶': "►",
+	'┃': "|",
+	'⌫': "Bksp",
+	'⌦': "Del",
+	'⇥': "Tab",
+	'⇪': "Caps",
+	'⏎': "Ent",
+	'↵': "Ent",
+	'⇧': "Shift",
+	'⌃': "Ctrl",
+	'⌥': "Alt",
+	'⌘': "Cmd",
+	'⏸': "||",
+	'⏭': ">>|",
+	'⏮': "|<<",
+}
+
+// affine is a 2D transform: x' = m[0]x + m[2]y + m[4], y' = m[1]x + m[3]y + m[5]
+type affine [6]float64
+
+// translation moves points by dx, dy
+func translation(dx, dy float64) affine {
+	return affine{1, 0, 0, 1, dx, dy}
+}
+
+// scaling scales points about the origin
+func scaling(s float64) affine {
+	return affine{s, 0, 0, s, 0, 0}
+}
+
+// rotation turns points by deg degrees clockwise (y down) about cx, cy
+func rotation(deg, cx, cy float64) affine {
+	rad := deg * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	return affine{cos, sin, -sin, cos, cx - cos*cx + sin*cy, cy - sin*cx - cos*cy}
+}
+
+// then returns the transform that applies m and then n
+func (m affine) then(n affine) affine {
+	return affine{
+		n[0]*m[0] + n[2]*m[1],
+		n[1]*m[0] + n[3]*m[1],
+		n[0]*m[2] + n[2]*m[3],
+		n[1]*m[2] + n[3]*m[3],
+		n[0]*m[4] + n[2]*m[5] + n[4],
+		n[1]*m[4] + n[3]*m[5] + n[5],
+	}
+}
+
+// apply transforms a point
+func (m affine) apply(p parser.Point) parser.Point {
+	return parser.Point{X: m[0]*p.X + m[2]*p.Y + m[4], Y: m[1]*p.X + m[3]*p.Y + m[5]}
+}
+
+// applyAll transforms a list of points
+func (m affine) applyAll(points []parser.Point) []parser.Point {
+	out := make([]parser.Point, len(points))
+	for i, p := range points {
+		out[i] = m.apply(p)
+	}
+	return out
+}
+
+// canvas paints anti-aliased shapes and text onto an image. Shapes are given
+// in canvas pixels; callers transform them into place first.
+type canvas struct {
+	img  *image.RGBA
+	z    vector.Rasterizer
+	font *sfnt.Font
+	buf  sfnt.Buffer
+}
+
+// newCanvas returns a canvas of the given size filled with a color
+func newCanvas(width, height int, background string) (*canvas, error) {
+	f, err := loadFont()
+	if err != nil {
+		return nil, err
+	}
+	c := &canvas{img: image.NewRGBA(image.Rect(0, 0, width, height)), font: f}
+	draw.Draw(c.img, c.img.Bounds(), image.NewUniform(parseColor(background, 1)), image.Point{}, draw.Src)
+	return c, nil
+}
+
+// fill paints the inside of a set of closed contours. Overlapping contours
+// wound the same way merge and contours wound the other way cut holes.
+func (c *canvas) fill(contours [][]parser.Point, col color.Color) {
+	var points []parser.Point
+	for _, contour := range contours {
+		points = append(points, contour...)
+	}
+	if len(points) == 0 {
+		return
+	}
+	box := boundsOf(points)
+	minX, minY := int(math.Floor(box.X)), int(math.Floor(box.Y))
+	maxX, maxY := int(math.Ceil(box.X+box.W)), int(math.Ceil(box.Y+box.H))
+	if maxX <= minX || maxY <= minY {
+		return
+	}
+
+	c.z.Reset(maxX-minX, maxY-minY)
+	c.z.DrawOp = draw.Over
+	for _, contour := range contours {
+		if len(contour) < 3 {
+			continue
+		}
+		c.z.MoveTo(float32(contour[0].X-float64(minX)), float32(contour[0].Y-float64(minY)))
+		for _, p := range contour[1:] {
+			c.z.LineTo(float32(p.X-float64(minX)), float32(p.Y-float64(minY)))
+		}
+		c.z.ClosePath()
+	}
+	c.z.Draw(c.img, image.Rect(minX, minY, maxX, maxY), image.NewUniform(col), image.Point{})
+}
+
+// stroke paints a line of the given width along a path, dashed when dash
+// lists on and off lengths. Each segment becomes a quad, extended by half the
+// width at both ends so the joints are covered.
+func (c *canvas) stroke(path []parser.Point, closed bool, width float64, dash []float64, col color.Color) {
+	if closed && len(path) > 0 {
+		path = append(path[:len(path):len(path)], path[0])
+	}
+	var quads [][]parser.Point
+	dashIndex, dashLeft, on := 0, 0.0, true
+	if len(dash) > 0 {
+		dashLeft = dash[0]
+	}
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		length := math.Hypot(b.X-a.X, b.Y-a.Y)
+		if length == 0 {
+			continue
+		}
+		if len(dash) == 0 {
+			quads = append(quads, segmentQuad(a, b, 0, length, length, width))
+			continue
+		}
+		for pos := 0.0; pos < length; {
+			step := math.Min(dashLeft, length-pos)
+			if on {
+				quads = append(quads, segmentQuad(a, b, pos, pos+step, length, width))
+			}
+			pos += step
+			dashLeft -= step
+			if dashLeft <= 1e-9 {
+				dashIndex = (dashIndex + 1) % len(dash)
+				dashLeft = dash[dashIndex]
+				on = !on
+			}
+		}
+	}
+	c.fill(quads, col)
+}
+
+// segmentQuad returns the quad covering the part of segment a-b from length
+// from to length to, width wide, with square caps of half the width
+func segmentQuad(a, b parser.Point, from, to, length, width float64) []parser.Point {
+	dx, dy := (b.X-a.X)/length, (b.Y-a.Y)/length
+	nx, ny := -dy*width/2, dx*width/2
+	from -= width / 2
+	to += width / 2
+	p := parser.Point{X: a.X + dx*from, Y: a.Y + dy*from}
+	q := parser.Point{X: a.X + dx*to, Y: a.Y + dy*to}
+	return []parser.Point{
+		{X: p.X + nx, Y: p.Y + ny},
+		{X: q.X + nx, Y: q.Y + ny},
+		{X: q.X - nx, Y: q.Y - ny},
+		{X: p.X - nx, Y: p.Y - ny},
+	}
+}
+
+// circle returns a circle as a polygon
+func circle(cx, cy, r float64) []parser.Point {
+	points := make([]parser.Point, 4*curveSteps)
+	for i := range points {
+		a := 2 * math.Pi * float64(i) / float64(len(points))
+		points[i] = parser.Point{X: cx + r*math.Cos(a), Y: cy + r*math.Sin(a)}
+	}
+	return points
+}
+
+// roundCorners rounds every corner of a polygon with radius r, or less where
+// the neighbouring edges are too short for it
+func roundCorners(points []parser.Point, r float64) []parser.Point {
+	n := len(points)
+	var out []parser.Point
+	for i, v := range points {
+		prev, next := points[(i+n-1)%n], points[(i+1)%n]
+		lp, ln := math.Hypot(prev.X-v.X, prev.Y-v.Y), math.Hypot(next.X-v.X, next.Y-v.Y)
+		radius := math.Min(r, math.Min(lp, ln)/2)
+		if radius <= 0 {
+			out = append(out, v)
+			continue
+		}
+		a := parser.Point{X: v.X + (prev.X-v.X)/lp*radius, Y: v.Y + (prev.Y-v.Y)/lp*radius}
+		b := parser.Point{X: v.X + (next.X-v.X)/ln*radius, Y: v.Y + (next.Y-v.Y)/ln*radius}
+		out = append(out, quadCurve(a, v, b)...)
+	}
+	return out
+}
+
+// quadCurve returns points along a quadratic curve from a to b controlled by
+// ctrl, including both ends
+func quadCurve(a, ctrl, b parser.Point) []parser.Point {
+	points := make([]parser.Point, curveSteps+1)
+	for i := range points {
+		t := float64(i) / curveSteps
+		u := 1 - t
+		points[i] = parser.Point{
+			X: u*u*a.X + 2*u*t*ctrl.X + t*t*b.X,
+			Y: u*u*a.Y + 2*u*t*ctrl.Y + t*t*b.Y,
+		}
+	}
+	return points
+}
+
+// cubeCurve returns points along a cubic curve from a to b, excluding a
+func cubeCurve(a, c1, c2, b parser.Point) []parser.Point {
+	points := make([]parser.Point, curveSteps)
+	for i := range points {
+		t := float64(i+1) / curveSteps
+		u := 1 - t
+		points[i] = parser.Point{
+			X: u*u*u*a.X + 3*u*u*t*c1.X + 3*u*t*t*c2.X + t*t*t*b.X,
+			Y: u*u*u*a.Y + 3*u*u*t*c1.Y + 3*u*t*t*c2.Y + t*t*t*b.Y,
+		}
+	}
+	return points
+}
+
+// printable replaces the symbols of a label the font cannot draw with their
+// fallbacks
+func (c *canvas) printable(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if idx, err := c.font.GlyphIndex(&c.buf, r); err == nil && idx == 0 {
+			if fallback, ok := glyphFallbacks[r]; ok {
+				b.WriteString(fallback)
+				continue
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// textAdvance returns the width of a line of text at size pixels
+func (c *canvas) textAdvance(s string, size float64) float64 {
+	ppem := fixed.Int26_6(size * 64)
+	width := fixed.Int26_6(0)
+	for _, r := range s {
+		idx, err := c.font.GlyphIndex(&c.buf, r)
+		if err != nil {
+			continue
+		}
+		advance, err := c.font.GlyphAdvance(&c.buf, idx, ppem, font.HintingNone)
+		if err == nil {
+			width += advance
+		}
+	}
+	return float64(width) / 64
+}
+
+// text paints a line of text with its baseline at y. align places x at the
+// start (-1), middle (0) or end (1) of the line. The glyph outlines go
+// through m like any other shape, so the text turns with rotated keys.
+func (c *canvas) text(m affine, s string, x, y, size float64, align int, col color.Color) {
+	switch align {
+	case 0:
+		x -= c.textAdvance(s, size) / 2
+	case 1:
+		x -= c.textAdvance(s, size)
+	}
+
+	ppem := fixed.Int26_6(size * 64)
+	var contours [][]parser.Point
+	for _, r := range s {
+		idx, err := c.font.GlyphIndex(&c.buf, r)
+		if err != nil {
+			continue
+		}
+		segments, err := c.font.LoadGlyph(&c.buf, idx, ppem, nil)
+		if err != nil {
+			continue
+		}
+		// Glyph coordinates are in 1/64 pixels from the pen, y down
+		pt := func(p fixed.Point26_6) parser.Point {
+			return parser.Point{X: x + float64(p.X)/64, Y: y + float64(p.Y)/64}
+		}
+		var contour []parser.Point
+		for _, seg := range segments {
+			switch seg.Op {
+			case sfnt.SegmentOpMoveTo:
+				if len(contour) > 0 {
+					contours = append(contours, m.applyAll(contour))
+				}
+				contour = []parser.Point{pt(seg.Args[0])}
+			case sfnt.SegmentOpLineTo:
+				contour = append(contour, pt(seg.Args[0]))
+			case sfnt.SegmentOpQuadTo:
+				contour = append(contour, quadCurve(contour[len(contour)-1], pt(seg.Args[0]), pt(seg.Args[1]))[1:]...)
+			case sfnt.SegmentOpCubeTo:
+				contour = append(contour, cubeCurve(contour[len(contour)-1], pt(seg.Args[0]), pt(seg.Args[1]), pt(seg.Args[2]))...)
+			}
+		}
+		if len(contour) > 0 {
+			contours = append(contours, m.applyAll(contour))
+		}
+
+		advance, err := c.font.GlyphAdvance(&c.buf, idx, ppem, font.HintingNone)
+		if err == nil {
+			x += float64(advance) / 64
+		}
+	}
+	c.fill(contours, col)
+}
+
+// parseColor parses a "#rrggbb" color and applies an opacity
+func parseColor(hex string, opacity float64) color.NRGBA {
+	col := color.NRGBA{A: uint8(math.Round(255 * opacity))}
+	if len(hex) == 7 && hex[0] == '#' {
+		if v, err := strconv.ParseUint(hex[1:], 16, 32); err == nil {
+			col.R, col.G, col.B = uint8(v>>16), uint8(v>>8), uint8(v)
+		}
+	}
+	return col
+}
+
+// boundsOf returns the bounding box of a set of points
+func boundsOf(points []parser.Point) parser.Rect {
+	minX, minY, maxX, maxY := points[0].X, points[0].Y, points[0].X, points[0].Y
+	for _, p := range points[1:] {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	return parser.Rect{X: minX, Y: minY, W: maxX - minX, H: maxY - minY}
+}
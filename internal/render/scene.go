@@ -0,0 +1,497 @@
+// Package render draws keymap layers on their physical layouts for use outside
+// the browser: SVG, PNG, PDF and text. Every output is built from the same
+// Scene, so the pictures agree with each other and with the web viewer.
+package render
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"keyviewer/internal/parser"
+)
+
+// Drawing sizes in pixels, matching the web viewer
+const (
+	KeySize = 54.0 // One key unit
+	KeyGap  = 4.0  // Space between neighbouring keys
+	Margin  = 16.0 // Space around the keyboard
+
+	titleSize   = 16.0 // Title font size
+	titleHeight = 28.0 // Space taken by the title above the keyboard
+	keyRowSize  = 18.0 // Space taken by the layer color key under the title
+	tapSize     = 12.0 // Font size of the main legend
+	legendSize  = 9.0  // Font size of hold and shifted legends
+	cornerSize  = 8.0  // Font size of other layers' legends in compact scenes
+	minFontSize = 6.0  // Legends never shrink below this
+	charWidth   = 0.65 // Average glyph width relative to the font size
+	keyPadding  = 3.0  // Space between a legend and the key's edge
+	cornerRound = 4.0  // Key corner radius
+
+	// Combo boxes, in key units
+	comboWidth  = 0.6
+	comboHeight = 0.4
+)
+
+// Key is a physical key with the legends of one layer
+type Key struct {
+	Physical parser.PhysicalKey
+	Binding  int    // Binding index drawn on the key, -1 for none
+	Tap      string // Main legend: the binding's label or its custom name
+	Hold     string
+	Shifted  string
+	Category string // Color category, see KeyCategory
+	Color    string // Fill set by the keymap, overriding the category's
+	Custom   bool   // Tap is a custom name
+	Held     bool   // The key is held to reach this layer
+	Faded    bool   // Ghost keys and bindings marked as ghosts
+	// Legends of other layers in compact scenes: top-left, top-right,
+	// bottom-left and bottom-right, colored as Scene.Layers[1:]
+	Corners [4]string
+}
+
+// Combo is a combo drawn between the keys that trigger it
+type Combo struct {
+	Keys   []int        // Positions in Scene.Keys
+	Center parser.Point // Where the combo box goes, in key units
+	Label  string
+	Hold   string
+}
+
+// SceneLayer is a layer shown in a scene and the color of its legends
+type SceneLayer struct {
+	Name  string
+	Color string
+}
+
+// Scene is everything needed to draw one layer of a keymap
+type Scene struct {
+	Title  string
+	Keys   []Key
+	Combos []Combo
+	Extent parser.Rect  // Bounding box of keys and combo boxes, in key units
+	Layers []SceneLayer // Color key of compact scenes, empty for single layers
+}
+
+// LayerScene lays out layer index of a keymap on a layout. The layout's key
+// indices must be binding indices, as returned by parser.BindLayout.
+func LayerScene(keymap *parser.Keymap, layout *parser.Layout, index int) (*Scene, error) {
+	if index < 0 || index >= len(keymap.Layers) {
+		return nil, fmt.Errorf("layer %d out of range", index)
+	}
+	layer := keymap.Layers[index]
+
+	scene := &Scene{Title: keymap.Name + " — " + layer.Name}
+	byBinding := make(map[int]int)
+	for _, pk := range layout.Keys {
+		key := Key{Physical: pk, Binding: pk.Index, Faded: pk.Ghost}
+		if pk.Decal || pk.Ghost {
+			key.Binding = -1
+		}
+		if key.Binding >= 0 {
+			byBinding[key.Binding] = len(scene.Keys)
+		}
+		if key.Binding >= 0 && key.Binding < len(layer.Keys) {
+			label := layer.Keys[key.Binding]
+			key.Tap = label
+			key.Category = KeyCategory(label)
+			idx := strconv.Itoa(key.Binding)
+			if custom, ok := layer.CustomNames[idx]; ok && custom != "" {
+				key.Tap = custom
+				key.Custom = true
+			}
+			key.Color = layer.Colors[idx]
+			if legends, ok := layer.Legends[idx]; ok {
+				key.Hold = legends.Hold
+				key.Shifted = legends.Shifted
+				key.Held = legends.Type == "held"
+				key.Faded = key.Faded || legends.Type == "ghost"
+			}
+		} else if !pk.Decal {
+			key.Category = KeyCategory("")
+		}
+		scene.Keys = append(scene.Keys, key)
+	}
+
+	scene.Extent = parser.ComputeGeometry(layout).Extent
+	for _, combo := range visibleCombos(keymap) {
+		if !comboOnLayer(combo, layer.Name) {
+			continue
+		}
+		c := Combo{Label: combo.Label, Hold: combo.Hold}
+		for _, b := range combo.Positions {
+			if pos, ok := byBinding[b]; ok {
+				c.Keys = append(c.Keys, pos)
+				center := scene.Keys[pos].Physical.Center()
+				c.Center.X += center.X
+				c.Center.Y += center.Y
+			}
+		}
+		if len(c.Keys) == 0 {
+			continue
+		}
+		c.Center.X /= float64(len(c.Keys))
+		c.Center.Y /= float64(len(c.Keys))
+		scene.Combos = append(scene.Combos, c)
+		scene.Extent = unionRect(scene.Extent, parser.Rect{
+			X: c.Center.X - comboWidth/2, Y: c.Center.Y - comboHeight/2, W: comboWidth, H: comboHeight,
+		})
+	}
+	return scene, nil
+}
+
+// withLabels returns the key with f applied to each of its legends, for
+// outputs that cannot show every character
+func (k Key) withLabels(f func(string) string) Key {
+	k.Tap, k.Hold, k.Shifted = f(k.Tap), f(k.Hold), f(k.Shifted)
+	for i, label := range k.Corners {
+		k.Corners[i] = f(label)
+	}
+	return k
+}
+
+// withLabels returns the combo with f applied to its legends
+func (c Combo) withLabels(f func(string) string) Combo {
+	c.Label, c.Hold = f(c.Label), f(c.Hold)
+	return c
+}
+
+// visibleCombos returns the combos of a keymap that are drawn, leaving out
+// hidden ones
+func visibleCombos(keymap *parser.Keymap) []parser.Combo {
+	var combos []parser.Combo
+	for _, combo := range keymap.Combos {
+		if !combo.Hidden {
+			combos = append(combos, combo)
+		}
+	}
+	return combos
+}
+
+// comboOnLayer reports whether a combo is active on a layer
+func comboOnLayer(combo parser.Combo, layer string) bool {
+	if len(combo.Layers) == 0 {
+		return true
+	}
+	for _, l := range combo.Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// unionRect returns the bounding box of two rectangles
+func unionRect(a, b parser.Rect) parser.Rect {
+	x, y := math.Min(a.X, b.X), math.Min(a.Y, b.Y)
+	return parser.Rect{
+		X: x,
+		Y: y,
+		W: math.Max(a.X+a.W, b.X+b.W) - x,
+		H: math.Max(a.Y+a.H, b.Y+b.H) - y,
+	}
+}
+
+// KeyCategory returns the color category of a binding label, the same way the
+// web viewer picks key classes: "empty", "trans", "layer", "mod", "special",
+// or "" for ordinary keys
+func KeyCategory(label string) string {
+	switch {
+	case label == "":
+		return "empty"
+	case label == "▽":
+		return "trans"
+	case strings.HasPrefix(label, "[") && strings.HasSuffix(label, "]"):
+		return "layer"
+	// Layer-tap pattern: "KEY/L" with content on both sides of the slash
+	case strings.Contains(label, "/") && len(label) > 2 && !strings.HasPrefix(label, "/") && !strings.HasSuffix(label, "/"):
+		return "layer"
+	}
+	for _, mod := range []string{"CTRL", "ALT", "GUI", "SHFT", "SHIFT"} {
+		if strings.Contains(label, mod) {
+			return "mod"
+		}
+	}
+	for _, special := range []string{"BOOT", "BT", "BL", "LDR", "CAPS", "STUDIO"} {
+		if strings.HasPrefix(label, special) {
+			return "special"
+		}
+	}
+	return ""
+}
+
+// style is how keys of a category are painted
+type style struct {
+	Fill   string // "" for no fill
+	Stroke string
+	Text   string
+	Dashed bool
+}
+
+// theme is the set of colors a scene is painted with
+type theme struct {
+	Styles     map[string]style // Key paint per category
+	Background string
+	Title      string
+	Legend     string // Hold and shifted legends
+	Custom     string // Border of keys with a custom name
+	CustomDot  string
+	Held       string // Border of keys held to reach the layer
+	ComboLine  string
+	ComboFill  string
+	ComboText  string
+}
+
+// darkTheme has the colors of the web viewer (static/style.css)
+var darkTheme = &theme{
+	Styles: map[string]style{
+		"":        {Fill: "#2a2a4a", Stroke: "#3a3a5a", Text: "#cccccc"},
+		"empty":   {Fill: "", Stroke: "#2a2a3a", Text: "#444444"},
+		"trans":   {Fill: "#1a1a2a", Stroke: "#3a3a5a", Text: "#555555", Dashed: true},
+		"special": {Fill: "#3a3a5a", Stroke: "#3a3a5a", Text: "#aaaaff"},
+		"mod":     {Fill: "#2a3a4a", Stroke: "#3a3a5a", Text: "#88ccff"},
+		"layer":   {Fill: "#3a2a4a", Stroke: "#3a3a5a", Text: "#cc88ff"},
+	},
+	Background: "#16162a",
+	Title:      "#aaaaaa",
+	Legend:     "#888888",
+	Custom:     "#6a8a6a",
+	CustomDot:  "#88cc88",
+	Held:       "#8a8aff",
+	ComboLine:  "#6a6a9a",
+	ComboFill:  "#2a2a4a",
+	ComboText:  "#cccccc",
+}
+
+// printTheme is a light version of darkTheme for paper
+var printTheme = &theme{
+	Styles: map[string]style{
+		"":        {Fill: "#ffffff", Stroke: "#666666", Text: "#000000"},
+		"empty":   {Fill: "", Stroke: "#cccccc", Text: "#999999"},
+		"trans":   {Fill: "#f4f4f4", Stroke: "#999999", Text: "#999999", Dashed: true},
+		"special": {Fill: "#e8e8fa", Stroke: "#666666", Text: "#333399"},
+		"mod":     {Fill: "#e2f0fc", Stroke: "#666666", Text: "#1a4f80"},
+		"layer":   {Fill: "#f0e6fa", Stroke: "#666666", Text: "#6a2a9a"},
+	},
+	Background: "#ffffff",
+	Title:      "#000000",
+	Legend:     "#666666",
+	Custom:     "#4a7a4a",
+	CustomDot:  "#4a9a4a",
+	Held:       "#4a4acc",
+	ComboLine:  "#888888",
+	ComboFill:  "#ffffff",
+	ComboText:  "#000000",
+}
+
+// fadedOpacity is the opacity of ghost keys
+const fadedOpacity = 0.3
+
+// styleOf returns the paint of a key
+func (t *theme) styleOf(key Key) style {
+	if key.Physical.Decal {
+		return style{}
+	}
+	s := t.Styles[key.Category]
+	if key.Color != "" {
+		s.Fill = key.Color
+	}
+	return s
+}
+
+// keyStroke returns the border color and width of a key
+func (t *theme) keyStroke(key Key) (string, float64) {
+	switch {
+	case key.Held:
+		return t.Held, 2
+	case key.Custom:
+		return t.Custom, 2
+	}
+	return t.styleOf(key).Stroke, 1
+}
+
+// textWidth estimates the width of a legend in pixels
+func textWidth(s string, size float64) float64 {
+	return float64(utf8.RuneCountInString(s)) * size * charWidth
+}
+
+// fitLabel fits a legend into width pixels at up to size: on one line if it
+// fits, else split at a space onto two lines, shrinking the font as needed
+func fitLabel(label string, width, size float64) ([]string, float64) {
+	if label == "" {
+		return nil, size
+	}
+	lines := []string{label}
+	if textWidth(label, size) > width {
+		if words := strings.Fields(label); len(words) > 1 {
+			// Split where the longer line is shortest
+			best := -1.0
+			for i := 1; i < len(words); i++ {
+				a, b := strings.Join(words[:i], " "), strings.Join(words[i:], " ")
+				longest := math.Max(textWidth(a, size), textWidth(b, size))
+				if best < 0 || longest < best {
+					best = longest
+					lines = []string{a, b}
+				}
+			}
+		}
+	}
+	longest := 0.0
+	for _, line := range lines {
+		longest = math.Max(longest, textWidth(line, size))
+	}
+	if longest > width {
+		size = math.Max(minFontSize, size*width/longest)
+	}
+	return lines, size
+}
+
+// keyRect returns the primary rectangle of a key in pixels, before rotation,
+// shrunk by the gap between keys
+func keyRect(k parser.PhysicalKey) parser.Rect {
+	return parser.Rect{
+		X: k.X*KeySize + KeyGap/2,
+		Y: k.Y*KeySize + KeyGap/2,
+		W: k.W*KeySize - KeyGap,
+		H: k.H*KeySize - KeyGap,
+	}
+}
+
+// keyOutline returns the outline of a key in pixels, before rotation, shrunk
+// by half the gap on every side. Outlines only have right angles, so each
+// corner moves diagonally along the normals of its two edges.
+func keyOutline(k parser.PhysicalKey) []parser.Point {
+	outline := k.Outline()
+	inset := make([]parser.Point, len(outline))
+	n := len(outline)
+	for i, p := range outline {
+		prev, next := outline[(i+n-1)%n], outline[(i+1)%n]
+		// Inward normals of a clockwise outline in y-down coordinates
+		in1x, in1y := -sign(p.Y-prev.Y), sign(p.X-prev.X)
+		in2x, in2y := -sign(next.Y-p.Y), sign(next.X-p.X)
+		inset[i] = parser.Point{
+			X: p.X*KeySize + (in1x+in2x)*KeyGap/2,
+			Y: p.Y*KeySize + (in1y+in2y)*KeyGap/2,
+		}
+	}
+	return inset
+}
+
+// sign returns -1, 0 or 1
+func sign(v float64) float64 {
+	switch {
+	case v > 1e-9:
+		return 1
+	case v < -1e-9:
+		return -1
+	}
+	return 0
+}
+
+// legendSpot is a legend placed in a key, in pixels before the key's rotation
+type legendSpot struct {
+	Lines []string
+	X, Y  float64 // Anchor of the text block: its center, or the middle of its left or right side
+	Size  float64
+	Color string
+	Align int // -1 to start the text at X, 0 to center it on X, 1 to end it at X
+}
+
+// keyLegends places the tap, shifted and hold legends of a key
+func (t *theme) keyLegends(key Key) []legendSpot {
+	r := keyRect(key.Physical)
+	width := r.W - 2*keyPadding
+	cx, cy := r.X+r.W/2, r.Y+r.H/2
+	text := t.styleOf(key).Text
+
+	var spots []legendSpot
+	if key.Shifted != "" {
+		lines, size := fitLabel(key.Shifted, width, legendSize)
+		spots = append(spots, legendSpot{lines[:1], cx, r.Y + keyPadding + size/2, size, t.Legend, 0})
+	}
+	if key.Tap != "" {
+		lines, size := fitLabel(key.Tap, width, tapSize)
+		spots = append(spots, legendSpot{lines, cx, cy, size, text, 0})
+	}
+	if key.Hold != "" {
+		lines, size := fitLabel(key.Hold, width, legendSize)
+		spots = append(spots, legendSpot{lines[:1], cx, r.Y + r.H - keyPadding - size/2, size, t.Legend, 0})
+	}
+	return append(spots, cornerLegends(key, r)...)
+}
+
+// cornerLegends places the legends of other layers in the corners of a key
+// of a compact scene, each corner taking at most half the key's width
+func cornerLegends(key Key, r parser.Rect) []legendSpot {
+	var spots []legendSpot
+	for corner, label := range key.Corners {
+		if label == "" {
+			continue
+		}
+		lines, size := fitLabel(label, r.W/2-keyPadding, cornerSize)
+		spot := legendSpot{Lines: lines[:1], Size: size, Color: layerColor(corner + 1)}
+		spot.X, spot.Align = r.X+keyPadding, -1
+		if corner%2 == 1 {
+			spot.X, spot.Align = r.X+r.W-keyPadding, 1
+		}
+		spot.Y = r.Y + keyPadding + size/2
+		if corner >= 2 {
+			spot.Y = r.Y + r.H - keyPadding - size/2
+		}
+		spots = append(spots, spot)
+	}
+	return spots
+}
+
+// canvasSize returns the size of the picture of a scene in pixels and the
+// offset that moves key coordinates (in pixels) into it
+func canvasSize(scene *Scene) (width, height float64, offset parser.Point) {
+	header := titleHeight
+	if len(scene.Layers) > 0 {
+		header += keyRowSize
+	}
+	width = scene.Extent.W*KeySize + 2*Margin
+	height = scene.Extent.H*KeySize + 2*Margin + header
+	offset = parser.Point{
+		X: Margin - scene.Extent.X*KeySize,
+		Y: Margin + header - scene.Extent.Y*KeySize,
+	}
+	return width, height, offset
+}
+
+// colorKeyItem is an entry of the layer color key under a scene's title
+type colorKeyItem struct {
+	X     float64 // Left of the color swatch, relative to the canvas
+	Name  string
+	Color string
+}
+
+// colorKey lays out the layer color key of a compact scene in a row. The
+// swatches are keyRowSize/2 square and the names start right after them.
+func colorKey(scene *Scene) []colorKeyItem {
+	items := make([]colorKeyItem, len(scene.Layers))
+	x := Margin
+	for i, layer := range scene.Layers {
+		items[i] = colorKeyItem{X: x, Name: layer.Name, Color: layer.Color}
+		x += keyRowSize/2 + 4 + textWidth(layer.Name, legendSize) + 12
+	}
+	return items
+}
+
+// comboLegends places the label of a combo, and its hold label under it, in
+// the combo's box. Positions are in pixels.
+func (t *theme) comboLegends(c Combo) []legendSpot {
+	cx, cy := c.Center.X*KeySize, c.Center.Y*KeySize
+	width := comboWidth*KeySize - 2*keyPadding
+	label, size := fitLabel(c.Label, width, legendSize)
+	if c.Hold == "" {
+		return []legendSpot{{label[:min(len(label), 1)], cx, cy, size, t.ComboText, 0}}
+	}
+	hold, holdSize := fitLabel(c.Hold, width, legendSize)
+	return []legendSpot{
+		{label[:min(len(label), 1)], cx, cy - size*0.55, size, t.ComboText, 0},
+		{hold[:1], cx, cy + holdSize*0.55, holdSize, t.Legend, 0},
+	}
+}
@@ -0,0 +1,173 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"strconv"
+
+	"keyviewer/internal/parser"
+)
+
+// lineHeight is the distance between lines of a legend relative to its size
+const lineHeight = 1.15
+
+// SVG draws a scene as a standalone SVG document
+func SVG(scene *Scene) []byte {
+	return StackedSVG([]*Scene{scene})
+}
+
+// StackedSVG draws scenes one below the other in a single SVG document
+func StackedSVG(scenes []*Scene) []byte {
+	width, height := 0.0, 0.0
+	for _, scene := range scenes {
+		w, h, _ := canvasSize(scene)
+		width = math.Max(width, w)
+		height += h
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %s %s" font-family="sans-serif">`+"\n",
+		num(width), num(height), num(width), num(height))
+	fmt.Fprintf(&b, `<rect width="100%%" height="100%%" fill="%s"/>`+"\n", darkTheme.Background)
+	top := 0.0
+	for _, scene := range scenes {
+		writeScene(&b, scene, top)
+		_, h, _ := canvasSize(scene)
+		top += h
+	}
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// writeScene draws a scene with its title, top pixels down the document
+func writeScene(b *bytes.Buffer, scene *Scene, top float64) {
+	_, _, offset := canvasSize(scene)
+	if scene.Title != "" {
+		fmt.Fprintf(b, `<text x="%s" y="%s" font-size="%s" fill="%s">%s</text>`+"\n",
+			num(Margin), num(top+Margin+titleSize), num(titleSize), darkTheme.Title, escape(scene.Title))
+	}
+	for _, item := range colorKey(scene) {
+		y := top + Margin + titleHeight
+		fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" rx="2" fill="%s"/>`+"\n",
+			num(item.X), num(y), num(keyRowSize/2), num(keyRowSize/2), item.Color)
+		fmt.Fprintf(b, `<text x="%s" y="%s" font-size="%s" fill="%s">%s</text>`+"\n",
+			num(item.X+keyRowSize/2+4), num(y+keyRowSize/4+legendSize*0.35), num(legendSize), item.Color, escape(item.Name))
+	}
+
+	fmt.Fprintf(b, `<g transform="translate(%s %s)">`+"\n", num(offset.X), num(top+offset.Y))
+	for _, key := range scene.Keys {
+		writeKey(b, key)
+	}
+	for _, combo := range scene.Combos {
+		writeCombo(b, scene, combo)
+	}
+	b.WriteString("</g>\n")
+}
+
+// writeKey draws a key with its legends, rotated into place
+func writeKey(b *bytes.Buffer, key Key) {
+	k := key.Physical
+	if k.Decal {
+		return
+	}
+	b.WriteString("<g")
+	if k.R != 0 {
+		fmt.Fprintf(b, ` transform="rotate(%s %s %s)"`, num(k.R), num(k.RX*KeySize), num(k.RY*KeySize))
+	}
+	if key.Faded {
+		fmt.Fprintf(b, ` opacity="%s"`, num(fadedOpacity))
+	}
+	b.WriteString(">\n")
+
+	s := darkTheme.styleOf(key)
+	stroke, strokeWidth := darkTheme.keyStroke(key)
+	fill := s.Fill
+	if fill == "" {
+		fill = "none"
+	}
+	paint := fmt.Sprintf(`fill="%s" stroke="%s" stroke-width="%s"`, fill, stroke, num(strokeWidth))
+	if s.Dashed {
+		paint += ` stroke-dasharray="3 2"`
+	}
+
+	outline := keyOutline(k)
+	if len(outline) == 4 {
+		r := keyRect(k)
+		fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" rx="%s" %s/>`+"\n",
+			num(r.X), num(r.Y), num(r.W), num(r.H), num(cornerRound), paint)
+	} else {
+		fmt.Fprintf(b, `<path d="%s" stroke-linejoin="round" %s/>`+"\n", pathData(outline), paint)
+	}
+	if key.Custom {
+		r := keyRect(k)
+		fmt.Fprintf(b, `<circle cx="%s" cy="%s" r="2.5" fill="%s"/>`+"\n",
+			num(r.X+r.W-5), num(r.Y+5), darkTheme.CustomDot)
+	}
+	for _, spot := range darkTheme.keyLegends(key) {
+		writeLegend(b, spot)
+	}
+	b.WriteString("</g>\n")
+}
+
+// writeCombo draws a combo's box with dashed lines to the keys that trigger it
+func writeCombo(b *bytes.Buffer, scene *Scene, c Combo) {
+	cx, cy := c.Center.X*KeySize, c.Center.Y*KeySize
+	for _, pos := range c.Keys {
+		center := scene.Keys[pos].Physical.Center()
+		fmt.Fprintf(b, `<line x1="%s" y1="%s" x2="%s" y2="%s" stroke="%s" stroke-dasharray="2 2"/>`+"\n",
+			num(cx), num(cy), num(center.X*KeySize), num(center.Y*KeySize), darkTheme.ComboLine)
+	}
+	w, h := comboWidth*KeySize, comboHeight*KeySize
+	fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" rx="3" fill="%s" stroke="%s"/>`+"\n",
+		num(cx-w/2), num(cy-h/2), num(w), num(h), darkTheme.ComboFill, darkTheme.ComboLine)
+	for _, spot := range darkTheme.comboLegends(c) {
+		writeLegend(b, spot)
+	}
+}
+
+// writeLegend draws the lines of a legend centered on its spot
+func writeLegend(b *bytes.Buffer, spot legendSpot) {
+	anchor := "middle"
+	switch spot.Align {
+	case -1:
+		anchor = "start"
+	case 1:
+		anchor = "end"
+	}
+	for i, line := range spot.Lines {
+		y := spot.Y + (float64(i)-float64(len(spot.Lines)-1)/2)*spot.Size*lineHeight
+		// Shift the baseline down so the text is centered vertically on y
+		fmt.Fprintf(b, `<text x="%s" y="%s" font-size="%s" fill="%s" text-anchor="%s">%s</text>`+"\n",
+			num(spot.X), num(y+spot.Size*0.35), num(spot.Size), spot.Color, anchor, escape(line))
+	}
+}
+
+// pathData returns the SVG path of a closed outline
+func pathData(points []parser.Point) string {
+	var b bytes.Buffer
+	for i, p := range points {
+		if i == 0 {
+			b.WriteString("M")
+		} else {
+			b.WriteString(" L")
+		}
+		b.WriteString(num(p.X) + " " + num(p.Y))
+	}
+	b.WriteString(" Z")
+	return b.String()
+}
+
+// num formats a coordinate with at most two decimals
+func num(v float64) string {
+	// Adding zero turns -0 into 0
+	return strconv.FormatFloat(math.Round(v*100)/100+0, 'f', -1, 64)
+}
+
+// escape escapes text for use in XML
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
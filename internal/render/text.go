@@ -0,0 +1,310 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Text styles: plain ASCII borders, or Unicode box-drawing characters
+const (
+	TextASCII = "ascii"
+	TextBox   = "box"
+)
+
+// Text layout sizes
+const (
+	textUnitWidth = 7   // Characters per key unit, one border included
+	textRowGap    = 1.5 // Rows further apart than this, in key units, get a blank line between them
+)
+
+// Border directions of a text cell, combined into a bit mask
+const (
+	edgeUp = 1 << iota
+	edgeDown
+	edgeLeft
+	edgeRight
+)
+
+// boxRunes are the box-drawing characters for each combination of borders
+var boxRunes = map[int]rune{
+	edgeUp: '│', edgeDown: '│', edgeUp | edgeDown: '│',
+	edgeLeft: '─', edgeRight: '─', edgeLeft | edgeRight: '─',
+	edgeDown | edgeRight: '┌', edgeDown | edgeLeft: '┐',
+	edgeUp | edgeRight: '└', edgeUp | edgeLeft: '┘',
+	edgeUp | edgeDown | edgeRight: '├', edgeUp | edgeDown | edgeLeft: '┤',
+	edgeDown | edgeLeft | edgeRight: '┬', edgeUp | edgeLeft | edgeRight: '┴',
+	edgeUp | edgeDown | edgeLeft | edgeRight: '┼',
+}
+
+// asciiFallbacks stand in for label symbols in ASCII text, before the PDF
+// and glyph fallbacks are tried
+var asciiFallbacks = map[rune]string{
+	'▽': "___",
+	'—': "-",
+	'–': "-",
+}
+
+// textBox is a key placed on the character grid: its border columns and lines
+type textBox struct {
+	left, right int
+	top, bottom int
+	label       string
+}
+
+// Text draws a scene's keys as text, each key a box with its tap legend, for
+// terminals and source comments. Keys snap to a character grid by their
+// position; rotated keys go where their rotated centers are, so thumb clusters
+// stay under the keys they sit below. Keys in the same row share borders.
+// Combos, titles and the other legends are left out.
+func Text(scene *Scene, style string) (string, error) {
+	if style == "" {
+		style = TextBox
+	}
+	if style != TextASCII && style != TextBox {
+		return "", fmt.Errorf("unknown text style %q", style)
+	}
+
+	type placed struct {
+		key       Key
+		left, top float64 // Top-left corner of the key, unrotated around its center
+	}
+	var keys []placed
+	minX := math.Inf(1)
+	for _, key := range scene.Keys {
+		k := key.Physical
+		if k.Decal || k.Ghost {
+			continue
+		}
+		c := k.Center()
+		left := c.X - k.W/2
+		keys = append(keys, placed{key, left, c.Y - k.H/2})
+		minX = math.Min(minX, left)
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	sort.SliceStable(keys, func(a, b int) bool { return keys[a].top < keys[b].top })
+
+	// Rows start at the top of their first key and take the keys starting
+	// less than half a key below it; each row gets a border line and a legend line
+	var rowTops []float64
+	var rowLines []int
+	row := make([]int, len(keys))
+	for i, p := range keys {
+		if len(rowTops) == 0 || p.top-rowTops[len(rowTops)-1] >= 0.5 {
+			line := 0
+			if n := len(rowTops); n > 0 {
+				line = rowLines[n-1] + 2
+				if p.top-rowTops[n-1] > textRowGap {
+					line++
+				}
+			}
+			rowTops = append(rowTops, p.top)
+			rowLines = append(rowLines, line)
+		}
+		row[i] = len(rowTops) - 1
+	}
+
+	boxes := make([]textBox, len(keys))
+	for i, p := range keys {
+		k := p.key.Physical
+		// Taller keys reach down through the rows starting above their bottom
+		last := row[i]
+		for last+1 < len(rowTops) && rowTops[last+1] < p.top+k.H-0.5 {
+			last++
+		}
+		boxes[i] = textBox{
+			left:   int(math.Round((p.left - minX) * textUnitWidth)),
+			right:  int(math.Round((p.left + k.W - minX) * textUnitWidth)),
+			top:    rowLines[row[i]],
+			bottom: rowLines[last] + 2,
+			label:  p.key.Tap,
+		}
+	}
+
+	// Keys that rounding or rotation made overlap move right, past the boxes
+	// placed before them; a key one column short of its neighbour joins it
+	order := make([]int, len(boxes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		if boxes[order[a]].top != boxes[order[b]].top {
+			return boxes[order[a]].top < boxes[order[b]].top
+		}
+		return boxes[order[a]].left < boxes[order[b]].left
+	})
+	for n, i := range order {
+		b := &boxes[i]
+		if b.right-b.left < 2 {
+			b.right = b.left + 2
+		}
+		// overlap returns the right border of a placed box that b would
+		// overlap between left and right
+		overlap := func(left, right int) (int, bool) {
+			for _, j := range order[:n] {
+				p := boxes[j]
+				if b.top < p.bottom && p.top < b.bottom && left < p.right && p.left < right {
+					return p.right, true
+				}
+			}
+			return 0, false
+		}
+		for {
+			edge, ok := overlap(b.left, b.right)
+			if !ok {
+				break
+			}
+			b.right += edge - b.left
+			b.left = edge
+		}
+		for _, j := range order[:n] {
+			p := boxes[j]
+			if b.top < p.bottom && p.top < b.bottom && b.left == p.right+1 {
+				if _, ok := overlap(b.left-1, b.right-1); !ok {
+					b.left--
+					b.right--
+				}
+				break
+			}
+		}
+	}
+
+	width, height := 0, 0
+	for _, b := range boxes {
+		width = max(width, b.right+1)
+		height = max(height, b.bottom+1)
+	}
+	grid := newTextGrid(width, height)
+	for _, b := range boxes {
+		grid.box(b)
+	}
+	for _, b := range boxes {
+		label := b.label
+		if style == TextASCII {
+			label = asciiLabel(label)
+		}
+		grid.label(b, label)
+	}
+	return grid.String(style), nil
+}
+
+// StackedText draws scenes one below the other, each under its title
+func StackedText(scenes []*Scene, style string) (string, error) {
+	var b strings.Builder
+	for i, scene := range scenes {
+		text, err := Text(scene, style)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if scene.Title != "" {
+			title := scene.Title
+			if style == TextASCII {
+				title = asciiLabel(title)
+			}
+			b.WriteString(title + "\n\n")
+		}
+		b.WriteString(text)
+	}
+	return b.String(), nil
+}
+
+// asciiLabel replaces the characters of a label outside ASCII with their
+// fallbacks, or "?"
+func asciiLabel(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case asciiFallbacks[r] != "":
+			b.WriteString(asciiFallbacks[r])
+		case pdfFallbacks[r] != "":
+			b.WriteString(asciiLabel(pdfFallbacks[r]))
+		case glyphFallbacks[r] != "":
+			b.WriteString(asciiLabel(glyphFallbacks[r]))
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// textGrid is a character grid with box borders drawn on it
+type textGrid struct {
+	width int
+	runes [][]rune
+	edges [][]int
+}
+
+func newTextGrid(width, height int) *textGrid {
+	g := &textGrid{width: width, runes: make([][]rune, height), edges: make([][]int, height)}
+	for y := range g.runes {
+		g.runes[y] = make([]rune, width)
+		g.edges[y] = make([]int, width)
+	}
+	return g
+}
+
+// box draws the borders of a box; borders it shares with other boxes join up
+func (g *textGrid) box(b textBox) {
+	for x := b.left; x < b.right; x++ {
+		for _, y := range []int{b.top, b.bottom} {
+			g.edges[y][x] |= edgeRight
+			g.edges[y][x+1] |= edgeLeft
+		}
+	}
+	for y := b.top; y < b.bottom; y++ {
+		for _, x := range []int{b.left, b.right} {
+			g.edges[y][x] |= edgeDown
+			g.edges[y+1][x] |= edgeUp
+		}
+	}
+}
+
+// label writes a label centered in a box, cut to fit between its borders
+func (g *textGrid) label(b textBox, label string) {
+	runes := []rune(label)
+	inner := b.right - b.left - 1
+	if len(runes) > inner {
+		runes = runes[:inner]
+	}
+	x := b.left + 1 + (inner-len(runes)+1)/2
+	y := (b.top + b.bottom) / 2
+	for i, r := range runes {
+		g.runes[y][x+i] = r
+	}
+}
+
+// String renders the grid in a text style, without trailing spaces
+func (g *textGrid) String(style string) string {
+	var b strings.Builder
+	for y, row := range g.runes {
+		line := make([]rune, g.width)
+		for x, r := range row {
+			edges := g.edges[y][x]
+			switch {
+			case r != 0:
+				line[x] = r
+			case edges == 0:
+				line[x] = ' '
+			case style == TextBox:
+				line[x] = boxRunes[edges]
+			case edges&(edgeUp|edgeDown) != 0 && edges&(edgeLeft|edgeRight) != 0:
+				line[x] = '+'
+			case edges&(edgeUp|edgeDown) != 0:
+				line[x] = '|'
+			default:
+				line[x] = '-'
+			}
+		}
+		b.WriteString(strings.TrimRight(string(line), " "))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
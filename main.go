@@ -3,11 +3,16 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"keyviewer/internal/api"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		os.Exit(runRender(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// Serve static files
 	fs := http.FileServer(http.Dir("static"))
 	http.Handle("/", fs)
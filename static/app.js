@@ -4,12 +4,13 @@ let currentLayout = null;
 let currentKeymap = null;
 let currentLayerIndex = 0;
 let selectedKeyIndex = null;
+let currentPositions = null; // Binding index for each layout key, from the server
 
 const KEY_SIZE = 54; // Base key size in pixels
 const KEY_GAP = 4;   // Gap between keys
 
 // DOM elements (assigned in init)
-let layoutFile, layoutSelect, keymapFile, keymapSelect;
+let layoutFile, layoutSelect, layoutVariants, keymapFile, keymapSelect;
 let jsonOpenFile, jsonSaveBtn;
 let layerTabs, keyboardContainer, statusMessage;
 let keyEditor, keyIndexDisplay, keyOriginalDisplay, keyFriendlyInput;
@@ -20,6 +21,7 @@ document.addEventListener('DOMContentLoaded', () => {
     // Get DOM elements
     layoutFile = document.getElementById('layout-file');
     layoutSelect = document.getElementById('layout-select');
+    layoutVariants = document.getElementById('layout-variants');
     keymapFile = document.getElementById('keymap-file');
     keymapSelect = document.getElementById('keymap-select');
     jsonOpenFile = document.getElementById('json-open-file');
@@ -90,6 +92,24 @@ async function handleLayoutUpload(event) {
     const formData = new FormData();
     formData.append('layout', file);
 
+    // QMK info.json files may define several LAYOUT_* variants. VIA and Vial
+    // definitions keep their options in the layout instead.
+    try {
+        const layouts = JSON.parse(await file.text()).layouts || {};
+        const variants = Array.isArray(layouts.keymap) ? [] : Object.keys(layouts);
+        if (variants.length > 1) {
+            const defaultVariant = variants.includes('LAYOUT') ? 'LAYOUT' : variants.sort()[0];
+            const variant = prompt(`Layout variant to import:\n${variants.join('\n')}`, defaultVariant);
+            if (variant === null) {
+                setStatus('Layout upload cancelled');
+                return;
+            }
+            formData.append('variant', variant);
+        }
+    } catch (error) {
+        // Not JSON the browser can read, let the server report it
+    }
+
     try {
         const response = await fetch('/api/layout', {
             method: 'POST',
@@ -100,13 +120,23 @@ async function handleLayoutUpload(event) {
             throw new Error(await response.text());
         }
 
-        currentLayout = await response.json();
-        setStatus(`Layout "${currentLayout.name}" uploaded (${currentLayout.keys.length} keys)`);
+        const result = await response.json();
+        currentLayout = result.layout;
+
+        const warnings = result.warnings || [];
+        warnings.forEach(warning => console.warn(`Layout ${warning.kind}: ${warning.message}`));
+        const warningInfo = warnings.length ? `, ${warnings.length} warning${warnings.length === 1 ? '' : 's'}: ${warnings[0].message}` : '';
+        setStatus(`Layout "${currentLayout.name}" uploaded (${currentLayout.keys.length} keys${warningInfo})`, warnings.length > 0);
 
         await loadLayoutList();
         layoutSelect.value = currentLayout.name;
 
-        renderKeyboard();
+        // Layouts with options are shown as built with the default choices
+        if (currentLayout.variants) {
+            await fetchLayout(currentLayout.name);
+        }
+        renderVariantControls();
+        loadPositions();
     } catch (error) {
         setStatus('Layout error: ' + error.message, true);
         console.error('Layout upload failed:', error);
@@ -117,23 +147,80 @@ async function handleLayoutSelect(event) {
     const name = event.target.value;
     if (!name) {
         currentLayout = null;
+        currentPositions = null;
+        renderVariantControls();
         renderKeyboard();
         return;
     }
 
     try {
-        const response = await fetch(`/api/layout/${name}`);
-        if (!response.ok) throw new Error('Failed to load layout');
-
-        currentLayout = await response.json();
+        await fetchLayout(name);
         setStatus(`Layout "${name}" loaded (${currentLayout.keys.length} keys)`);
-        renderKeyboard();
+        renderVariantControls();
+        loadPositions();
+
+        // Remember the layout for the keymap being viewed
+        if (currentKeymap) {
+            fetch(`/api/keymap/${encodeURIComponent(currentKeymap.name)}/layout`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ name })
+            }).catch(error => console.error('Failed to associate layout:', error));
+        }
     } catch (error) {
         setStatus('Failed to load layout', true);
         console.error('Failed to load layout:', error);
     }
 }
 
+// Fetch a stored layout with its geometry, built with the given option choices
+async function fetchLayout(name, variant = '') {
+    const query = variant ? `&variant=${encodeURIComponent(variant)}` : '';
+    const response = await fetch(`/api/layout/${encodeURIComponent(name)}?geometry=1${query}`);
+    if (!response.ok) throw new Error('Failed to load layout');
+    currentLayout = await response.json();
+}
+
+// Load a stored layout and select it, refreshing the list in case it is new
+async function showStoredLayout(name) {
+    await loadLayoutList();
+    await fetchLayout(name);
+    layoutSelect.value = name;
+    renderVariantControls();
+}
+
+// Show a select for each option group of the layout (split backspace, ISO enter...)
+function renderVariantControls() {
+    layoutVariants.innerHTML = '';
+    (currentLayout?.variants || []).forEach(variant => {
+        const select = document.createElement('select');
+        select.title = variant.name || `Option ${variant.group}`;
+        variant.choices.forEach((choice, i) => {
+            const option = document.createElement('option');
+            option.value = i;
+            option.textContent = `${variant.name || `Option ${variant.group}`}: ${choice}`;
+            select.appendChild(option);
+        });
+        select.value = variant.selected;
+        select.dataset.group = variant.group;
+        select.addEventListener('change', handleVariantChange);
+        layoutVariants.appendChild(select);
+    });
+}
+
+async function handleVariantChange() {
+    const variant = [...layoutVariants.querySelectorAll('select')]
+        .map(select => `${select.dataset.group}:${select.value}`)
+        .join(',');
+    try {
+        await fetchLayout(layoutSelect.value, variant);
+        renderKeyboard();
+    } catch (error) {
+        setStatus('Failed to load layout variant', true);
+        console.error('Failed to load layout variant:', error);
+    }
+}
+
 // Keymap functions
 async function loadKeymapList() {
     try {
@@ -171,16 +258,26 @@ async function handleKeymapUpload(event) {
             throw new Error(await response.text());
         }
 
-        currentKeymap = await response.json();
+        // The server associates the stored layout that clearly matches, and ranks the rest
+        const { layoutSuggestions = [], ...keymap } = await response.json();
+        currentKeymap = keymap;
         currentLayerIndex = 0;
 
+        let layoutInfo = '';
+        if (keymap.layoutRef) {
+            await showStoredLayout(keymap.layoutRef.name);
+            layoutInfo = `, layout "${keymap.layoutRef.name}"`;
+        } else if (layoutSuggestions.length && layoutSuggestions[0].score > 0.5) {
+            layoutInfo = `, closest layout: ${layoutSuggestions[0].layout} (${Math.round(layoutSuggestions[0].score * 100)}%)`;
+        }
+
         const keyCount = currentKeymap.layers[0]?.keys?.length || 0;
-        setStatus(`Keymap "${currentKeymap.name}" uploaded (${currentKeymap.layers.length} layers, ${keyCount} keys)`);
+        setStatus(`Keymap "${currentKeymap.name}" uploaded (${currentKeymap.layers.length} layers, ${keyCount} keys${layoutInfo})`);
 
         await loadKeymapList();
         keymapSelect.value = currentKeymap.name;
 
-        renderKeyboard();
+        loadPositions();
     } catch (error) {
         setStatus('Keymap error: ' + error.message, true);
         console.error('Keymap upload failed:', error);
@@ -191,35 +288,42 @@ async function handleKeymapSelect(event) {
     const name = event.target.value;
     if (!name) {
         currentKeymap = null;
+        currentPositions = null;
         layerTabs.innerHTML = '';
         renderKeyboard();
         return;
     }
 
     try {
-        const response = await fetch(`/api/keymap/${name}`);
+        const response = await fetch(`/api/keymap/${name}?withLayout=1`);
         if (!response.ok) throw new Error('Failed to load keymap');
 
-        const data = await response.json();
+        const { keymap: data, layout, layoutChanged } = await response.json();
 
-        // Extract embedded layout if present
-        if (data.layout && data.layout.keys && Array.isArray(data.layout.keys)) {
-            currentLayout = data.layout;
-            if (data.layout.name) {
-                layoutSelect.value = data.layout.name;
+        // Show the layout the keymap is associated with, or the one embedded in it
+        if (data.layoutRef) {
+            await showStoredLayout(data.layoutRef.name);
+        } else if (layout && Array.isArray(layout.keys)) {
+            currentLayout = layout;
+            if (layout.name) {
+                layoutSelect.value = layout.name;
             }
+            renderVariantControls();
         }
 
         // Set keymap without layout property
         currentKeymap = {
             name: data.name,
-            layers: data.layers
+            layers: data.layers,
+            combos: data.combos,
+            drawer: data.drawer
         };
         currentLayerIndex = 0;
 
         const layoutInfo = currentLayout ? `, layout: ${currentLayout.keys.length} keys` : '';
-        setStatus(`Keymap "${name}" loaded${layoutInfo}`);
-        renderKeyboard();
+        const changedInfo = layoutChanged ? ' (layout changed since it was associated)' : '';
+        setStatus(`Keymap "${name}" loaded${layoutInfo}${changedInfo}`);
+        loadPositions();
     } catch (error) {
         setStatus('Failed to load keymap', true);
         console.error('Failed to load keymap:', error);
@@ -243,6 +347,15 @@ function getKeyLabel(index) {
     return layer.keys[index] || '';
 }
 
+// Get the most prominent KLE legend of a physical key (center, then top-left, then any)
+function getPrimaryLegend(physKey) {
+    const labels = physKey.labels || [];
+    for (const slot of [4, 0, 1, 3, 6, 7, 2, 5, 8, 9, 10, 11]) {
+        if (labels[slot]) return labels[slot];
+    }
+    return '';
+}
+
 // Check if a key has a custom name
 function hasCustomName(index) {
     if (!currentKeymap) return false;
@@ -350,13 +463,7 @@ function renderKeyboard() {
     keyboard.className = 'keyboard';
 
     // Calculate bounds for centering
-    let minX = Infinity, minY = Infinity, maxX = 0, maxY = 0;
-    currentLayout.keys.forEach(key => {
-        minX = Math.min(minX, key.x);
-        minY = Math.min(minY, key.y);
-        maxX = Math.max(maxX, key.x + key.w);
-        maxY = Math.max(maxY, key.y + key.h);
-    });
+    const { minX, minY, maxX, maxY } = layoutExtent();
 
     const width = (maxX - minX) * KEY_SIZE + KEY_GAP;
     const height = (maxY - minY) * KEY_SIZE + KEY_GAP;
@@ -364,9 +471,20 @@ function renderKeyboard() {
     keyboard.style.height = height + 'px';
 
     // Render each key
-    currentLayout.keys.forEach((physKey, index) => {
+    currentLayout.keys.forEach((physKey) => {
         const keyEl = document.createElement('div');
-        const label = getKeyLabel(index);
+
+        // Decals and ghost keys carry no binding
+        if (physKey.decal || physKey.ghost) {
+            keyEl.className = 'key ' + (physKey.decal ? 'decal' : 'ghost');
+            positionKey(keyEl, physKey, minX, minY);
+            keyboard.appendChild(keyEl);
+            return;
+        }
+
+        const index = bindingIndex(physKey);
+        // Without a keymap, show the legends printed in the layout file
+        const label = currentKeymap ? getKeyLabel(index) : getPrimaryLegend(physKey);
         const originalKey = getOriginalKey(index);
         const isCustom = hasCustomName(index);
         const isSelected = index === selectedKeyIndex;
@@ -377,27 +495,10 @@ function renderKeyboard() {
         keyEl.dataset.index = index;
         keyEl.title = `Key ${index}: ${label || 'empty'}${isCustom ? ' (custom)' : ''}\nClick to select, double-click to edit inline`;
 
-        // Position and size
-        const x = (physKey.x - minX) * KEY_SIZE;
-        const y = (physKey.y - minY) * KEY_SIZE;
-        const w = physKey.w * KEY_SIZE - KEY_GAP;
-        const h = physKey.h * KEY_SIZE - KEY_GAP;
-
-        keyEl.style.left = x + 'px';
-        keyEl.style.top = y + 'px';
-        keyEl.style.width = w + 'px';
-        keyEl.style.height = h + 'px';
-
-        // Rotation
-        if (physKey.r !== 0) {
-            const rx = (physKey.rx - minX) * KEY_SIZE;
-            const ry = (physKey.ry - minY) * KEY_SIZE;
-            keyEl.style.transformOrigin = `${rx - x}px ${ry - y}px`;
-            keyEl.style.transform = `rotate(${physKey.r}deg)`;
-        }
+        positionKey(keyEl, physKey, minX, minY);
 
         // Click to select, double-click to edit (only if keymap is loaded)
-        if (currentKeymap) {
+        if (currentKeymap && index >= 0) {
             keyEl.addEventListener('click', (e) => {
                 e.preventDefault();
                 selectKey(index);
@@ -417,6 +518,71 @@ function renderKeyboard() {
     updateKeyEditor();
 }
 
+// Fetch which binding lands on each layout key, then render. Without a stored
+// layout or keymap, bindings fall on keys by index.
+async function loadPositions() {
+    currentPositions = null;
+    const layoutName = layoutSelect.value;
+    if (currentLayout && currentKeymap && layoutName) {
+        try {
+            const response = await fetch(`/api/layout/${encodeURIComponent(layoutName)}/positions?keymap=${encodeURIComponent(currentKeymap.name)}`);
+            if (response.ok) {
+                currentPositions = await response.json();
+            }
+        } catch (error) {
+            console.error('Failed to load key positions:', error);
+        }
+    }
+    renderKeyboard();
+}
+
+// Get the binding index shown on a physical key, -1 if no binding lands on it
+function bindingIndex(physKey) {
+    if (currentPositions && currentPositions.bindings) {
+        return currentPositions.bindings[physKey.index] ?? -1;
+    }
+    return physKey.index;
+}
+
+// Get the layout's bounding box in key units. Layouts fetched with geometry
+// carry their rotated extent; otherwise the unrotated key rectangles are used.
+function layoutExtent() {
+    const extent = currentLayout.geometry?.extent;
+    if (extent) {
+        return { minX: extent.x, minY: extent.y, maxX: extent.x + extent.w, maxY: extent.y + extent.h };
+    }
+
+    let minX = Infinity, minY = Infinity, maxX = 0, maxY = 0;
+    currentLayout.keys.forEach(key => {
+        minX = Math.min(minX, key.x);
+        minY = Math.min(minY, key.y);
+        maxX = Math.max(maxX, key.x + key.w);
+        maxY = Math.max(maxY, key.y + key.h);
+    });
+    return { minX, minY, maxX, maxY };
+}
+
+// Position, size and rotate a key element
+function positionKey(keyEl, physKey, minX, minY) {
+    const x = (physKey.x - minX) * KEY_SIZE;
+    const y = (physKey.y - minY) * KEY_SIZE;
+    const w = physKey.w * KEY_SIZE - KEY_GAP;
+    const h = physKey.h * KEY_SIZE - KEY_GAP;
+
+    keyEl.style.left = x + 'px';
+    keyEl.style.top = y + 'px';
+    keyEl.style.width = w + 'px';
+    keyEl.style.height = h + 'px';
+
+    // Rotation
+    if (physKey.r !== 0) {
+        const rx = (physKey.rx - minX) * KEY_SIZE;
+        const ry = (physKey.ry - minY) * KEY_SIZE;
+        keyEl.style.transformOrigin = `${rx - x}px ${ry - y}px`;
+        keyEl.style.transform = `rotate(${physKey.r}deg)`;
+    }
+}
+
 function renderLayerTabs() {
     layerTabs.innerHTML = '';
 
@@ -563,20 +729,33 @@ async function handleJsonOpen(event) {
 
     try {
         const text = await file.text();
-        const keymap = JSON.parse(text);
+        let importUrl = '/api/keymap/import';
 
-        // Validate keymap structure
-        if (!keymap.name || !keymap.layers || !Array.isArray(keymap.layers)) {
-            throw new Error('Invalid keymap JSON structure');
+        // keymap-drawer YAML files are converted by the server
+        const isYaml = /\.ya?ml$/i.test(file.name);
+        if (isYaml) {
+            const baseName = file.name.replace(/\.ya?ml$/i, '');
+            importUrl += `?format=keymap-drawer&name=${encodeURIComponent(baseName)}`;
+        } else {
+            const keymap = JSON.parse(text);
+
+            const baseName = file.name.replace(/\.json$/i, '');
+            if (keymap.keyboard === 'glove80' && Array.isArray(keymap.layer_names)) {
+                // Glove80 Layout Editor export
+                importUrl += `?format=glove80&name=${encodeURIComponent(baseName)}`;
+            } else if ((keymap.data?.layout || keymap).revision?.layers) {
+                // ZSA Oryx layout export
+                importUrl += `?format=oryx&name=${encodeURIComponent(baseName)}`;
+            } else if (!keymap.name || !keymap.layers || !Array.isArray(keymap.layers)) {
+                // Validate keymap structure
+                throw new Error('Invalid keymap JSON structure');
+            }
         }
 
-        // Extract embedded layout if present (for self-contained keymap files)
-        const embeddedLayout = keymap.layout || null;
-
         // Upload to server to persist
-        const response = await fetch('/api/keymap/import', {
+        const response = await fetch(importUrl, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/json' },
+            headers: { 'Content-Type': isYaml ? 'application/yaml' : 'application/json' },
             body: text
         });
 
@@ -584,22 +763,20 @@ async function handleJsonOpen(event) {
             throw new Error(await response.text());
         }
 
+        // Layouts embedded in self-contained keymap files are stored by the server
         const savedKeymap = await response.json();
 
         // Set currentKeymap (without the layout property to keep it clean)
         currentKeymap = {
             name: savedKeymap.name,
-            layers: savedKeymap.layers
+            layers: savedKeymap.layers,
+            combos: savedKeymap.combos,
+            drawer: savedKeymap.drawer
         };
         currentLayerIndex = 0;
 
-        // Set currentLayout from embedded layout if present
-        if (embeddedLayout && embeddedLayout.keys && Array.isArray(embeddedLayout.keys)) {
-            currentLayout = embeddedLayout;
-            // Update layout selector if the layout has a name
-            if (embeddedLayout.name) {
-                layoutSelect.value = embeddedLayout.name;
-            }
+        if (savedKeymap.layoutRef) {
+            await showStoredLayout(savedKeymap.layoutRef.name);
         }
 
         const keyCount = currentKeymap.layers[0]?.keys?.length || 0;
@@ -609,7 +786,7 @@ async function handleJsonOpen(event) {
         await loadKeymapList();
         keymapSelect.value = currentKeymap.name;
 
-        renderKeyboard();
+        loadPositions();
     } catch (error) {
         setStatus('JSON error: ' + error.message, true);
         console.error('JSON open failed:', error);